@@ -0,0 +1,340 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A backup archive is a gzipped tar file of a manifest and the dump of the
+// database as the payload.
+var (
+	BACKUP_FORMAT_VERSION = 1
+	BACKUP_MANIFEST       = "manifest.json"
+	BACKUP_PAYLOAD        = "dump.txt"
+	GZIP_MAGIC            = []byte{0x1f, 0x8b}
+)
+
+// BackupManifest describes the payload of a backup archive.
+type BackupManifest struct {
+	FormatVersion int    `json:"format_version"`
+	CnoteVersion  string `json:"cnote_version"`
+	Created       string `json:"created"`
+	// note -> number of items
+	Notes  map[string]int `json:"notes"`
+	Size   int            `json:"size"`
+	SHA256 string         `json:"sha256"`
+}
+
+func (manifest *BackupManifest) String() string {
+	items := 0
+	for _, n := range manifest.Notes {
+		items += n
+	}
+	return fmt.Sprintf("backup: %d notes, %d items, %d bytes (created: %s, sha256: %s).",
+		len(manifest.Notes), items, manifest.Size, manifest.Created, manifest.SHA256)
+}
+
+// countItems returns the notes in dump with the number of their items.
+func countItems(dump []byte) map[string]int {
+	notes := make(map[string]int, 0)
+	for _, line := range strings.Split(string(dump), "\n") {
+		i := strings.Index(line, "\t")
+		if i < 0 {
+			continue
+		}
+		key := line[:i]
+
+		switch {
+		case strings.HasPrefix(key, NOTE_PREFIX):
+			notename := strings.TrimPrefix(key, NOTE_PREFIX)
+			if _, ok := notes[notename]; !ok {
+				notes[notename] = 0
+			}
+		case strings.HasPrefix(key, ITEM_PREFIX):
+			notename, _, ok := parseItemKey(key)
+			if !ok {
+				continue
+			}
+			notes[notename]++
+		}
+	}
+	return notes
+}
+
+// Backup writes the database as a backup archive to filename, which is
+// replaced only when the archive is complete.
+func (notedb *NoteDB) Backup(filename string) (*BackupManifest, error) {
+	var payload bytes.Buffer
+	err := notedb.Dump(&payload, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	checksum := sha256.Sum256(payload.Bytes())
+	manifest := &BackupManifest{
+		FormatVersion: BACKUP_FORMAT_VERSION,
+		CnoteVersion:  VERSION,
+		Created:       time.Now().Format(TIME_FORMAT),
+		Notes:         countItems(payload.Bytes()),
+		Size:          payload.Len(),
+		SHA256:        hex.EncodeToString(checksum[:]),
+	}
+	manifestdata, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name()) // renamed when done
+
+	gz := gzip.NewWriter(tmp)
+	tw := tar.NewWriter(gz)
+	for _, file := range []struct {
+		name string
+		data []byte
+	}{
+		{BACKUP_MANIFEST, manifestdata},
+		{BACKUP_PAYLOAD, payload.Bytes()},
+	} {
+		err = tw.WriteHeader(&tar.Header{
+			Name:    file.name,
+			Mode:    0600,
+			Size:    int64(len(file.data)),
+			ModTime: time.Now(),
+		})
+		if err != nil {
+			tmp.Close()
+			return nil, err
+		}
+		_, err = tw.Write(file.data)
+		if err != nil {
+			tmp.Close()
+			return nil, err
+		}
+	}
+
+	for _, closer := range []io.Closer{tw, gz, tmp} {
+		err = closer.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = os.Rename(tmp.Name(), filename)
+	if err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// isBackup reports whether the file read by r is a backup archive.
+func isBackup(r *bufio.Reader) bool {
+	head, _ := r.Peek(len(GZIP_MAGIC))
+	return bytes.Equal(head, GZIP_MAGIC)
+}
+
+// readBackup reads a backup archive, and returns the manifest and the
+// payload after verifying the checksum and the numbers of items.
+func readBackup(r io.Reader) (*BackupManifest, []byte, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, errors.New(fmt.Sprintf("invalid backup archive. %v", err))
+	}
+	defer gz.Close()
+
+	var manifest *BackupManifest
+	var payload []byte
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, errors.New(fmt.Sprintf("invalid backup archive. %v", err))
+		}
+
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, nil, errors.New(fmt.Sprintf("invalid backup archive. %v", err))
+		}
+
+		switch header.Name {
+		case BACKUP_MANIFEST:
+			manifest = &BackupManifest{}
+			err = json.Unmarshal(data, manifest)
+			if err != nil {
+				return nil, nil, errors.New(fmt.Sprintf("invalid manifest of backup. %v", err))
+			}
+		case BACKUP_PAYLOAD:
+			payload = data
+		}
+	}
+	if manifest == nil || payload == nil {
+		return nil, nil, errors.New("invalid backup archive, manifest or payload missing.")
+	}
+
+	if manifest.FormatVersion > BACKUP_FORMAT_VERSION {
+		return nil, nil, errors.New(fmt.Sprintf(
+			"backup format version %d not supported, made by cnote %s.",
+			manifest.FormatVersion, manifest.CnoteVersion))
+	}
+
+	checksum := sha256.Sum256(payload)
+	if len(payload) != manifest.Size || hex.EncodeToString(checksum[:]) != manifest.SHA256 {
+		return nil, nil, errors.New("backup corrupted, checksum mismatch.")
+	}
+
+	notes := countItems(payload)
+	names := make([]string, 0, len(manifest.Notes))
+	for notename, _ := range manifest.Notes {
+		names = append(names, notename)
+	}
+	sort.Strings(names)
+	for _, notename := range names {
+		n, ok := notes[notename]
+		if !ok || n != manifest.Notes[notename] {
+			return nil, nil, errors.New(fmt.Sprintf(
+				"backup corrupted, %d items of note \"%s\" expected, %d found.",
+				manifest.Notes[notename], notename, n))
+		}
+	}
+	if len(notes) != len(manifest.Notes) {
+		return nil, nil, errors.New(fmt.Sprintf(
+			"backup corrupted, %d notes expected, %d found.",
+			len(manifest.Notes), len(notes)))
+	}
+
+	return manifest, payload, nil
+}
+
+// suffix of the directory of automatic backups, next to the database
+var BACKUP_DIR_SUFFIX = ".backups"
+
+// backupDir returns the directory of automatic backups.
+func (notedb *NoteDB) backupDir() string {
+	return notedb.dbfile + BACKUP_DIR_SUFFIX
+}
+
+// backupBefore backs up the database before the destructive operation op,
+// if "backup.auto" is on. Operations called by others are not backed up
+// again.
+func (notedb *NoteDB) backupBefore(op string) error {
+	if notedb.tx != nil || !notedb.Config.GetBool("backup.auto") {
+		return nil
+	}
+
+	_, err := notedb.AutoBackup(op)
+	if err != nil {
+		return errors.New(fmt.Sprintf("fail to back up the database before %s, nothing changed. %v",
+			op, err))
+	}
+	return nil
+}
+
+// countWrite counts a write to the database, and backs it up after every
+// "backup.every" writes.
+func (notedb *NoteDB) countWrite() error {
+	every := notedb.Config.GetInt("backup.every")
+	if every <= 0 {
+		return nil
+	}
+
+	notedb.Config.Writes++
+	if notedb.Config.Writes < every {
+		return nil
+	}
+	notedb.Config.Writes = 0
+
+	_, err := notedb.AutoBackup("auto")
+	if err != nil {
+		return errors.New(fmt.Sprintf("changes saved, but fail to back up the database. %v", err))
+	}
+	return nil
+}
+
+// AutoBackup backs up the database into the directory of automatic backups
+// for the reason, and removes the oldest ones beyond "backup.keep". The
+// name of the backup is returned.
+func (notedb *NoteDB) AutoBackup(reason string) (string, error) {
+	err := os.MkdirAll(notedb.backupDir(), 0700)
+	if err != nil {
+		return "", err
+	}
+
+	name := fmt.Sprintf("%s-%s.tgz", time.Now().Format("20060102-150405.000"), reason)
+	_, err = notedb.Backup(filepath.Join(notedb.backupDir(), name))
+	if err != nil {
+		return "", err
+	}
+
+	names, err := notedb.Backups()
+	if err != nil {
+		return "", err
+	}
+	keep := notedb.Config.GetInt("backup.keep")
+	if keep < 1 { // the new one
+		keep = 1
+	}
+	for len(names) > keep {
+		err = os.Remove(filepath.Join(notedb.backupDir(), names[0]))
+		if err != nil {
+			return "", err
+		}
+		names = names[1:]
+	}
+	return name, nil
+}
+
+// Backups returns the names of automatic backups, from the oldest.
+func (notedb *NoteDB) Backups() ([]string, error) {
+	files, err := ioutil.ReadDir(notedb.backupDir())
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), ".tgz") {
+			names = append(names, file.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// BackupPath returns the path of an automatic backup, which is verified.
+func (notedb *NoteDB) BackupPath(name string) (string, *BackupManifest, error) {
+	path := filepath.Join(notedb.backupDir(), filepath.Base(name))
+	fh, err := os.Open(path)
+	if err != nil {
+		return "", nil, errors.New(fmt.Sprintf("backup \"%s\" not exist.", name))
+	}
+	defer fh.Close()
+
+	manifest, _, err := readBackup(bufio.NewReader(fh))
+	if err != nil {
+		return "", nil, err
+	}
+	return path, manifest, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Rolling back to the oldest backup, which the automatic backup before
+// restoring removes from the rotation.
+func TestRollbackToOldestBackup(t *testing.T) {
+	notedb, close := newTestDB(t)
+	defer close()
+
+	for _, setting := range [][2]string{{"backup.auto", "true"}, {"backup.keep", "2"}} {
+		err := notedb.Config.Set(setting[0], setting[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, content := range []string{"apple", "pear"} {
+		_, err := notedb.AddNoteItem("fruit", content, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = notedb.AutoBackup("test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond) // names of backups differ in milliseconds
+	}
+
+	names, err := notedb.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 {
+		t.Fatalf("expected 2 backups, got %v", names)
+	}
+
+	path, _, err := notedb.BackupPath(names[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = notedb.Restore(path, nil)
+	if err != nil {
+		t.Fatalf("fail to roll back to the oldest backup: %s", err)
+	}
+
+	note, err := notedb.ReadNote("fruit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err := notedb.ReadNoteItems(note)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Content != "apple" {
+		t.Fatalf("expected the item \"apple\" of the oldest backup, got %v", items)
+	}
+
+	names, err = notedb.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 {
+		t.Fatalf("expected 2 backups after rotation, got %v", names)
+	}
+}
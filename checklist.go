@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// statuses of items. Items of checklist notes are ITEM_TODO when added,
+// and items of other notes have no status until done.
+var (
+	ITEM_TODO  = "todo"
+	ITEM_DOING = "doing"
+	// completed items are not listed by "cnote due"
+	ITEM_DONE = "done"
+)
+
+// SetItemStatus changes the status of an item of note. Status "" resets
+// it, which is ITEM_TODO for checklist notes.
+func (notedb *NoteDB) SetItemStatus(note *Note, itemid int, status string) (item *Item, err error) {
+	notedb.begin("SetItemStatus", noteName(note), strconv.Itoa(itemid), status)
+	defer func() { err = notedb.commit(err) }()
+
+	if !stringInSlice(status, []string{"", ITEM_TODO, ITEM_DOING, ITEM_DONE}) {
+		return nil, errors.New(fmt.Sprintf("invalid status \"%s\".", status))
+	}
+
+	item, err = notedb.ReadNoteItem(note, itemid)
+	if err != nil {
+		return nil, err
+	}
+
+	if status == "" && note.Checklist {
+		status = ITEM_TODO
+	}
+	if item.Status == status {
+		return item, nil
+	}
+
+	item.Status = status
+	err = notedb.saveItemFlags(note, item)
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// Progress returns the number of done items and all items of note.
+func (notedb *NoteDB) Progress(note *Note) (done, total int, err error) {
+	err = notedb.EachItem(note, func(item *Item) error {
+		if item.Status == ITEM_DONE {
+			done++
+		}
+		total++
+		return nil
+	})
+	return done, total, err
+}
@@ -2,40 +2,55 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/jinzhu/now"
 	"github.com/syndtr/goleveldb/leveldb"
-	"github.com/syndtr/goleveldb/leveldb/util"
-)
-
-var (
-	NOTE_PREFIX = "note_"
-	ITEM_PREFIX = "item_"
+	"github.com/syndtr/goleveldb/leveldb/opt"
 )
 
 type Config struct {
 	CurrentNoteName string `json:"current_note_name"`
+	// settings changed by "cnote config", see SETTINGS
+	Settings map[string]string `json:"settings,omitempty"`
+	// number of writes since the last automatic backup, see "backup.every"
+	Writes int `json:"writes,omitempty"`
 }
 
 func (conf *Config) Clone() *Config {
 	c := new(Config)
 	c.CurrentNoteName = conf.CurrentNoteName
+	c.Writes = conf.Writes
+	c.Settings = make(map[string]string, len(conf.Settings))
+	for key, value := range conf.Settings {
+		c.Settings[key] = value
+	}
 	return c
 }
 
 func (conf *Config) IsEqualTo(c *Config) bool {
-	if conf.CurrentNoteName != c.CurrentNoteName {
+	if conf.CurrentNoteName != c.CurrentNoteName || conf.Writes != c.Writes {
+		return false
+	}
+	if len(conf.Settings) != len(c.Settings) {
 		return false
 	}
+	for key, value := range conf.Settings {
+		if v, ok := c.Settings[key]; !ok || v != value {
+			return false
+		}
+	}
 	return true
 }
 
@@ -47,19 +62,122 @@ type Note struct {
 	// not that, the type of the key of interal map is string
 	// because the leveldb only allow string as key.
 	Tags map[string]map[string]bool `json:"tags"`
+	// alias -> tag, aliases are replaced by their tags when adding and
+	// querying items
+	Aliases map[string]string `json:"aliases,omitempty"`
+	// items of checklist notes are tasks with status, see ITEM_TODO
+	Checklist bool `json:"checklist,omitempty"`
+}
+
+// noteName returns the name of note, or "" for no note.
+func noteName(note *Note) string {
+	if note == nil {
+		return ""
+	}
+	return note.NoteID
+}
+
+// indexItem counts item in note and adds it to the tag index.
+func (note *Note) indexItem(item *Item) {
+	note.Sum++
+	for _, tag := range item.Tags {
+		if _, ok := note.Tags[tag]; !ok {
+			note.Tags[tag] = make(map[string]bool, 0)
+		}
+
+		note.Tags[tag][item.ItemID] = true
+	}
+	note.LastUpdate = now.BeginningOfMinute().String()
+}
 
-	Items map[int]*Item `json:"-"`
+// unindexItem reverts indexItem.
+func (note *Note) unindexItem(item *Item) {
+	note.Sum--
+	for _, tag := range item.Tags {
+		delete(note.Tags[tag], item.ItemID)
+
+		if len(note.Tags[tag]) == 0 {
+			delete(note.Tags, tag)
+		}
+	}
+	note.LastUpdate = now.BeginningOfMinute().String()
 }
 
 type Item struct {
 	ItemID  string   `json:"itemid"`
 	Tags    []string `json:"tags"`
 	Content string   `json:"content"`
+	Created string   `json:"created,omitempty"`
+	Updated string   `json:"updated,omitempty"`
+	// pinned items are listed first
+	Pinned bool   `json:"pinned,omitempty"`
+	Due    string `json:"due,omitempty"`
+	// "", ITEM_TODO, ITEM_DOING or ITEM_DONE
+	Status string `json:"status,omitempty"`
+	// key/value fields of records, like host=db1
+	Fields map[string]string `json:"fields,omitempty"`
+	// content of secret items is encrypted, see encryptSecret
+	Secret bool `json:"secret,omitempty"`
 }
 
 func (item *Item) String() string {
+	if item.Status != "" {
+		return fmt.Sprintf("item: %s\t(tags: %v, status: %s)\t%s",
+			item.ItemID, item.Tags, item.Status, item.ShownContent())
+	}
 	return fmt.Sprintf("item: %s\t(tags: %v)\t%s",
-		item.ItemID, item.Tags, item.Content)
+		item.ItemID, item.Tags, item.ShownContent())
+}
+
+// ShownContent returns the content of item, or SECRET_MASK for secret
+// items.
+func (item *Item) ShownContent() string {
+	if item.Secret {
+		return SECRET_MASK
+	}
+	return item.Content
+}
+
+// Details returns all fields of item of note, one per line.
+func (item *Item) Details(notename string) string {
+	return strings.Join([]string{
+		"item:    " + item.ItemID,
+		"note:    " + notename,
+		"tags:    " + strings.Join(item.Tags, TAG_SEPARATOR),
+		"created: " + item.Created,
+		"updated: " + item.Updated,
+		"pinned:  " + strconv.FormatBool(item.Pinned),
+		"due:     " + item.Due,
+		"status:  " + item.Status,
+		"fields:  " + strings.Join(item.FieldList(), " "),
+		"secret:  " + strconv.FormatBool(item.Secret),
+		"content: " + item.ShownContent(),
+	}, "\n")
+}
+
+// FieldList returns fields of item as key=value, sorted by key.
+func (item *Item) FieldList() []string {
+	keys := make([]string, 0, len(item.Fields))
+	for key, _ := range item.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	list := make([]string, 0, len(keys))
+	for _, key := range keys {
+		list = append(list, key+"="+item.Fields[key])
+	}
+	return list
+}
+
+// HasFields reports whether item has all fields with the same values.
+func (item *Item) HasFields(fields map[string]string) bool {
+	for key, value := range fields {
+		if v, ok := item.Fields[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
 }
 
 type NoteDB struct {
@@ -67,37 +185,49 @@ type NoteDB struct {
 	NotesList   []string
 	CurrentNote *Note
 
-	db     *leveldb.DB
-	dbfile string
+	db       *leveldb.DB
+	dbfile   string
+	readOnly bool
+	// private copy of a database locked by a writer, see ConnectDB
+	copydir string
 
 	oldConfig *Config
+	// running transaction, see begin
+	tx *txn
 }
 
-func NewNoteDB(dbfile string) *NoteDB {
+// NewNoteDB opens the database. A read-only database does not take the
+// write lock and never saves changes, so it can be opened while another
+// cnote process is writing.
+func NewNoteDB(dbfile string, wait time.Duration, readOnly bool) (*NoteDB, error) {
 	notedb := new(NoteDB)
 	notedb.dbfile = dbfile
+	notedb.readOnly = readOnly
 
-	notedb.ConnectDB()
+	err := notedb.ConnectDB(wait)
+	if err != nil {
+		return nil, err
+	}
 
 	notedb.ReadConfig()
 	notedb.oldConfig = notedb.Config.Clone()
 
 	// check the config
-	_, err := notedb.ReadNote(notedb.Config.CurrentNoteName)
+	_, err = notedb.ReadNote(notedb.Config.CurrentNoteName)
 	if err != nil {
 		notedb.Config.CurrentNoteName = ""
 	}
 
 	err = notedb.UseNote(notedb.Config.CurrentNoteName)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(0)
+		notedb.Close()
+		return nil, err
 	}
 
 	list, err := notedb.GetNotesList()
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(0)
+		notedb.Close()
+		return nil, err
 	}
 	notedb.NotesList = list
 
@@ -105,24 +235,93 @@ func NewNoteDB(dbfile string) *NoteDB {
 		fmt.Println("no notes in database, please create one.")
 	}*/
 
-	return notedb
+	return notedb, nil
 }
 
 //////////////////////////////////////////////////////
 
-func (notedb *NoteDB) ConnectDB() {
-	db, err := leveldb.OpenFile(notedb.dbfile, nil)
+// ConnectDB opens the database, retrying for at most wait if it is locked
+// by another cnote process.
+func (notedb *NoteDB) ConnectDB(wait time.Duration) error {
+	if notedb.readOnly {
+		return notedb.connectReadOnly(wait)
+	}
+
+	err := openWithRetry(notedb.dbfile, wait, func() error {
+		db, err := leveldb.OpenFile(notedb.dbfile, nil)
+		if err != nil {
+			return err
+		}
+		notedb.db = db
+		return nil
+	})
 	if err != nil {
-		fmt.Sprintf("fail to open leveldb file: %s. %s", notedb.dbfile, err)
-		os.Exit(0)
+		return err
+	}
+
+	writeLockOwner(notedb.dbfile)
+	return nil
+}
+
+// connectReadOnly opens a private copy of the database in read-only mode.
+// The shared lock of the database is only held while copying, so that a
+// read-only command, e.g. waiting for a passphrase, never blocks writers.
+// If a writer holds the database, it is copied without the lock.
+func (notedb *NoteDB) connectReadOnly(wait time.Duration) error {
+	// nothing to read yet, create an empty database
+	if _, err := os.Stat(notedb.dbfile); os.IsNotExist(err) {
+		notedb.readOnly = false
+		return notedb.ConnectDB(wait)
+	}
+
+	o := &opt.Options{ReadOnly: true}
+	db, err := leveldb.OpenFile(notedb.dbfile, o)
+	if err == nil {
+		// no writer can change the files while the lock is held
+		copydir, err := copyDB(notedb.dbfile)
+		db.Close()
+		if err != nil {
+			return errors.New(
+				fmt.Sprintf("fail to copy leveldb file: %s. %s", notedb.dbfile, err))
+		}
+		db, err = leveldb.OpenFile(copydir, o)
+		if err != nil {
+			os.RemoveAll(copydir)
+			return errors.New(
+				fmt.Sprintf("fail to open copy of leveldb file: %s. %s", notedb.dbfile, err))
+		}
+		notedb.db = db
+		notedb.copydir = copydir
+		return nil
+	}
+	if !isLockError(err) {
+		return errors.New(
+			fmt.Sprintf("fail to open leveldb file: %s. %s", notedb.dbfile, err))
+	}
+
+	db, copydir, err := openCopy(notedb.dbfile)
+	if err != nil {
+		return errors.New(
+			fmt.Sprintf("fail to copy locked leveldb file: %s. %s", notedb.dbfile, err))
 	}
 	notedb.db = db
+	notedb.copydir = copydir
+	return nil
 }
 
 func (notedb *NoteDB) Close() {
+	if notedb.readOnly {
+		notedb.db.Close()
+		if notedb.copydir != "" {
+			os.RemoveAll(notedb.copydir)
+		}
+		return
+	}
+
 	if !notedb.Config.IsEqualTo(notedb.oldConfig) {
 		notedb.SaveConfig()
 	}
+	removeLockOwner(notedb.dbfile)
 	notedb.db.Close()
 }
 
@@ -130,28 +329,25 @@ func (notedb *NoteDB) Close() {
 
 func (notedb *NoteDB) GetCurrentNote() (*Note, error) {
 	if notedb.CurrentNote == nil {
-		return nil, errors.New(
-			fmt.Sprintf("no note choosed from %v. Use \"cnote use notename\".",
-				notedb.NotesList))
+		return nil, notedb.noNoteError()
 	}
 	return notedb.CurrentNote, nil
 }
 
-func (notedb *NoteDB) ReadNote(notename string) (*Note, error) {
-	var note = &Note{}
-	key := NOTE_PREFIX + notename
-
-	err := notedb.ReadStruct(key, note)
-	if err != nil {
-		return nil, errors.New(
-			fmt.Sprintf("note \"%s\" not exist.", notename))
-	}
+// noNoteError is the error of operations on the current note when no note
+// is used.
+func (notedb *NoteDB) noNoteError() error {
+	return errors.New(
+		fmt.Sprintf("no note choosed from %v. Use \"cnote use notename\".",
+			notedb.NotesList))
+}
 
-	return note, nil
+func (notedb *NoteDB) ReadNote(notename string) (*Note, error) {
+	return readNote(notedb, notename)
 }
 
 func (notedb *NoteDB) SaveNote(note *Note) error {
-	key := NOTE_PREFIX + note.NoteID
+	key := noteKey(note.NoteID)
 	err := notedb.SaveStruct(key, note)
 	if err != nil {
 		return errors.New(fmt.Sprintf("fail to save %s. %v", key, err))
@@ -160,21 +356,35 @@ func (notedb *NoteDB) SaveNote(note *Note) error {
 	return nil
 }
 
-func (notedb *NoteDB) NewNote(notename string) error {
+func (notedb *NoteDB) NewNote(notename string, checklist bool) (err error) {
+	notedb.begin("NewNote", notename)
+	defer func() { err = notedb.commit(err) }()
+
+	err = CheckNoteName(notename)
+	if err != nil {
+		return err
+	}
 
 	// check whether note exists
-	_, err := notedb.ReadNote(notename)
+	_, err = notedb.ReadNote(notename)
 	if err == nil {
 		return errors.New(
 			fmt.Sprintf("note \"%s\" already exist.", notename))
 	}
 
+	// revisions left by a deleted note of the same name in former versions
+	err = notedb.moveKeys(historyNotePrefix(notename), trashHistoryNotePrefix(notename), 2)
+	if err != nil {
+		return err
+	}
+
 	note := &Note{
 		NoteID:     notename,
 		Sum:        0,
 		LastUpdate: now.BeginningOfMinute().String(),
 		LastId:     0,
 		Tags:       map[string]map[string]bool{},
+		Checklist:  checklist,
 	}
 
 	notedb.NotesList = append(notedb.NotesList, notename)
@@ -192,7 +402,15 @@ func (notedb *NoteDB) NewNote(notename string) error {
 	return nil
 }
 
-func (notedb *NoteDB) DeleteNote(notename string) error {
+// DeleteNote moves a note and all its items to the trash.
+func (notedb *NoteDB) DeleteNote(notename string) (err error) {
+	err = notedb.backupBefore("del")
+	if err != nil {
+		return err
+	}
+
+	notedb.begin("DeleteNote", notename)
+	defer func() { err = notedb.commit(err) }()
 
 	// read note
 	note, err := notedb.ReadNote(notename)
@@ -200,28 +418,35 @@ func (notedb *NoteDB) DeleteNote(notename string) error {
 		return err
 	}
 
-	// first, remove all items of the note
-	itemids := make(map[int]bool, 0)
-	for tag, _ := range note.Tags {
-		for itemid, _ := range note.Tags[tag] {
-
-			itemid, err := strconv.Atoi(itemid)
-			if err != nil {
-				return err
-			}
+	// the note is trashed as it is now, with the index of its items
+	trashnote := &TrashNote{
+		Note:      *note,
+		DeletedAt: time.Now().Format(TIME_FORMAT),
+	}
+	err = notedb.SaveStruct(trashNoteKey(notename), trashnote)
+	if err != nil {
+		return err
+	}
 
-			itemids[itemid] = true
-		}
+	// first, remove all items of the note, including untagged ones
+	items, err := notedb.ReadNoteItems(note)
+	if err != nil {
+		return err
 	}
-	for itemid, _ := range itemids {
-		err := notedb.RemoveNoteItem(note, itemid)
+	for _, item := range items {
+		itemid, err := strconv.Atoi(item.ItemID)
+		if err != nil {
+			return err
+		}
+
+		err = notedb.trashNoteItem(note, itemid, true)
 		if err != nil {
 			return err
 		}
 	}
 
 	// second, delete the note
-	key := NOTE_PREFIX + note.NoteID
+	key := noteKey(note.NoteID)
 	err = notedb.DeleteStruct(key)
 	if err != nil {
 		return err
@@ -237,12 +462,186 @@ func (notedb *NoteDB) DeleteNote(notename string) error {
 	notedb.NotesList = list
 
 	// update config
-	notedb.Config.CurrentNoteName = ""
-	notedb.CurrentNote = nil
+	if notedb.Config.CurrentNoteName == notename {
+		notedb.Config.CurrentNoteName = ""
+		notedb.CurrentNote = nil
+	}
 
 	return nil
 }
 
+// RenameNote renames a note, rewriting the keys of the note and all its
+// items in one batch.
+func (notedb *NoteDB) RenameNote(oldname, newname string) (err error) {
+	notedb.begin("RenameNote", oldname, newname)
+	defer func() { err = notedb.commit(err) }()
+
+	note, err := notedb.ReadNote(oldname)
+	if err != nil {
+		return err
+	}
+
+	err = CheckNoteName(newname)
+	if err != nil {
+		return err
+	}
+
+	_, err = notedb.ReadNote(newname)
+	if err == nil {
+		return errors.New(
+			fmt.Sprintf("note \"%s\" already exist.", newname))
+	}
+
+	// move items
+	err = notedb.moveKeys(itemPrefix(oldname), itemPrefix(newname), 1)
+	if err != nil {
+		return err
+	}
+
+	// move revisions of items
+	err = notedb.moveKeys(historyNotePrefix(oldname), historyNotePrefix(newname), 2)
+	if err != nil {
+		return err
+	}
+
+	// move removed items and their revisions, but not the ones of a deleted
+	// note of the same name
+	oldprefix, newprefix := trashItemPrefix(oldname), trashItemPrefix(newname)
+	err = notedb.ScanPrefix(oldprefix, func(key, value []byte) error {
+		if !keyOfNote(key, oldprefix, 1) {
+			return nil
+		}
+		trashitem := &TrashItem{}
+		err := json.Unmarshal(value, trashitem)
+		if err != nil || trashitem.WithNote {
+			return err
+		}
+
+		itemid, err := strconv.Atoi(trashitem.Item.ItemID)
+		if err != nil {
+			return err
+		}
+		err = notedb.moveKeys(trashHistoryPrefix(oldname, itemid),
+			trashHistoryPrefix(newname, itemid), 1)
+		if err != nil {
+			return err
+		}
+
+		trashitem.Note = newname
+		newkey := newprefix + strings.TrimPrefix(string(key), oldprefix)
+		err = notedb.SaveStruct(newkey, trashitem)
+		if err != nil {
+			return err
+		}
+		return notedb.delete(string(key))
+	})
+	if err != nil {
+		return err
+	}
+
+	// move note
+	note.NoteID = newname
+	err = notedb.SaveNote(note)
+	if err != nil {
+		return err
+	}
+	err = notedb.DeleteStruct(noteKey(oldname))
+	if err != nil {
+		return err
+	}
+
+	// update list
+	for i, n := range notedb.NotesList {
+		if n == oldname {
+			notedb.NotesList[i] = newname
+		}
+	}
+
+	// update config
+	if notedb.Config.CurrentNoteName == oldname {
+		notedb.Config.CurrentNoteName = newname
+		notedb.CurrentNote = note
+	}
+
+	return nil
+}
+
+// CopyNoteItems copies items of note to note destname, where they get new
+// IDs. If move is true, the items are removed from note. The copied items
+// are returned.
+func (notedb *NoteDB) CopyNoteItems(note *Note, itemids []int,
+	destname string, move bool) (items []*Item, err error) {
+
+	notedb.begin("CopyNoteItems", noteName(note), fmt.Sprint(itemids),
+		destname, strconv.FormatBool(move))
+	defer func() { err = notedb.commit(err) }()
+
+	if note == nil {
+		return nil, notedb.noNoteError()
+	}
+
+	var dest *Note
+	if destname == note.NoteID {
+		if move {
+			return nil, errors.New(
+				fmt.Sprintf("items already in note \"%s\".", destname))
+		}
+		dest = note
+	} else {
+		dest, err = notedb.ReadNote(destname)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	items = make([]*Item, 0)
+	for _, itemid := range itemids {
+		item, err := notedb.ReadNoteItem(note, itemid)
+		if err != nil {
+			return nil, err
+		}
+
+		dest.LastId++
+		copied := *item
+		copied.ItemID = fmt.Sprintf("%d", dest.LastId)
+
+		key := itemKey(dest.NoteID, dest.LastId)
+		err = notedb.SaveStruct(key, &copied)
+		if err != nil {
+			return nil, err
+		}
+		dest.indexItem(&copied)
+
+		err = notedb.copyHistory(note, itemid, dest, dest.LastId, move)
+		if err != nil {
+			return nil, err
+		}
+
+		if move {
+			err = notedb.DeleteStruct(itemKey(note.NoteID, itemid))
+			if err != nil {
+				return nil, err
+			}
+			note.unindexItem(item)
+		}
+
+		items = append(items, &copied)
+	}
+
+	err = notedb.SaveNote(dest)
+	if err != nil {
+		return nil, err
+	}
+	if move {
+		err = notedb.SaveNote(note)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return items, nil
+}
+
 func (notedb *NoteDB) UseNote(notename string) error {
 
 	// not note exists
@@ -266,59 +665,105 @@ func (notedb *NoteDB) UseNote(notename string) error {
 
 func (notedb *NoteDB) GetNotesList() ([]string, error) {
 	list := make([]string, 0)
-	iter := notedb.db.NewIterator(&util.Range{Start: []byte(NOTE_PREFIX)}, nil)
-	for iter.Next() {
-		key := iter.Key()
-		list = append(list, trim_prefix(NOTE_PREFIX, string(key)))
-	}
-	iter.Release()
-	err := iter.Error()
+	err := notedb.ScanPrefix(NOTE_PREFIX, func(key, value []byte) error {
+		list = append(list, strings.TrimPrefix(string(key), NOTE_PREFIX))
+		return nil
+	})
 	return list, err
 }
 
-func (notedb *NoteDB) AddNoteItem(tagstring, content string) (*Item, error) {
+// ItemOptions are optional properties of a new item.
+type ItemOptions struct {
+	Due    time.Time // zero for no due date
+	Fields map[string]string
+	// encrypt the content with the passphrase
+	Passphrase string
+	// the content is encrypted already, like secret items imported
+	Secret bool
+	// "" for ITEM_TODO in checklist notes, and no status in others
+	Status string
+	Pinned bool
+}
+
+// itemOptions returns the options of adding a copy of item, e.g. imported
+// from a dump.
+func itemOptions(item *Item) *ItemOptions {
+	return &ItemOptions{
+		Due:    parseTimestamp(item.Due),
+		Fields: item.Fields,
+		Secret: item.Secret,
+		Status: item.Status,
+		Pinned: item.Pinned,
+	}
+}
+
+func (notedb *NoteDB) AddNoteItem(tagstring, content string, opts *ItemOptions) (item *Item, err error) {
+	secret := opts != nil && (opts.Passphrase != "" || opts.Secret)
+	if secret { // not journaled
+		notedb.begin("AddNoteItem", noteName(notedb.CurrentNote), tagstring, SECRET_MASK)
+	} else {
+		notedb.begin("AddNoteItem", noteName(notedb.CurrentNote), tagstring, content)
+	}
+	defer func() { err = notedb.commit(err) }()
+
+	if secret && len(opts.Fields) > 0 {
+		return nil, errors.New("fields of secret items are not encrypted, " +
+			"put them into the content.")
+	}
+	if opts != nil && opts.Passphrase != "" {
+		content, err = encryptSecret(content, opts.Passphrase)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	note, err := notedb.GetCurrentNote()
 	if err != nil {
 		return nil, err
 	}
 
-	tags := make([]string, 0)
-	re := regexp.MustCompile(`^\s*$`)
-	for _, tag := range strings.Split(tagstring, ",") {
-		// empty
-		if re.MatchString(tag) {
-			continue
+	// normalized, aliases replaced, empty ones removed
+	tags := notedb.resolveTags(note, strings.Split(tagstring, TAG_SEPARATOR))
+	for _, tag := range tags {
+		err = CheckTag(tag)
+		if err != nil {
+			return nil, err
 		}
-
-		tags = append(tags, tag)
 	}
 
 	notedb.CurrentNote.LastId++
 
-	item := &Item{
+	item = &Item{
 		ItemID:  fmt.Sprintf("%d", notedb.CurrentNote.LastId),
 		Tags:    tags,
 		Content: content,
+		Created: time.Now().Format(TIME_FORMAT),
+	}
+	item.Updated = item.Created
+	if opts != nil {
+		item.Status = opts.Status
+		item.Pinned = opts.Pinned
+	}
+	if item.Status == "" && note.Checklist {
+		item.Status = ITEM_TODO
+	}
+	if opts != nil && len(opts.Fields) > 0 {
+		item.Fields = opts.Fields
+	}
+	item.Secret = secret
+	if opts != nil && !opts.Due.IsZero() {
+		item.Due = opts.Due.Format(TIME_FORMAT)
 	}
 
 	// save item
-	key := fmt.Sprintf("%s%s_%09d", ITEM_PREFIX,
-		notedb.CurrentNote.NoteID, notedb.CurrentNote.LastId)
+	key := itemKey(notedb.CurrentNote.NoteID, notedb.CurrentNote.LastId)
 	err = notedb.SaveStruct(key, item)
 	if err != nil {
 		return nil, errors.New(fmt.Sprintf("fail to save %s. %v", key, err))
 	}
 
 	// update current note
-	note.Sum++
-	for _, tag := range tags {
-		if _, ok := note.Tags[tag]; !ok {
-			note.Tags[tag] = make(map[string]bool, 0)
-		}
-
-		note.Tags[tag][item.ItemID] = true
-	}
-	note.LastUpdate = now.BeginningOfMinute().String()
+	note.indexItem(item)
 
 	// save note
 	err = notedb.SaveNote(note)
@@ -331,14 +776,11 @@ func (notedb *NoteDB) AddNoteItem(tagstring, content string) (*Item, error) {
 
 func (notedb *NoteDB) ReadNoteItem(note *Note, itemid int) (*Item, error) {
 	if note == nil {
-		return nil, errors.New(
-			fmt.Sprintf("no note choosed from %v. Use \"cnote use notename\".",
-				notedb.NotesList))
+		return nil, notedb.noNoteError()
 	}
 
 	var item = &Item{}
-	key := fmt.Sprintf("%s%s_%09d", ITEM_PREFIX,
-		note.NoteID, itemid)
+	key := itemKey(note.NoteID, itemid)
 	err := notedb.ReadStruct(key, item)
 	if err != nil {
 		return nil, errors.New(
@@ -349,30 +791,133 @@ func (notedb *NoteDB) ReadNoteItem(note *Note, itemid int) (*Item, error) {
 	return item, nil
 }
 
-func (notedb *NoteDB) RemoveNoteItem(note *Note, itemid int) error {
-	item, err := notedb.ReadNoteItem(note, itemid)
+// EachItem calls fn for every item of note in the order of item ID. Items
+// are read by a prefix scan, without going through the tag index.
+func (notedb *NoteDB) EachItem(note *Note, fn func(item *Item) error) error {
+	if note == nil {
+		return notedb.noNoteError()
+	}
+
+	return eachItem(notedb, note.NoteID, fn)
+}
+
+// ReadNoteItems returns all items of note in the order of item ID.
+func (notedb *NoteDB) ReadNoteItems(note *Note) ([]*Item, error) {
+	items := make([]*Item, 0)
+	err := notedb.EachItem(note, func(item *Item) error {
+		items = append(items, item)
+		return nil
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return items, nil
+}
 
-	key := fmt.Sprintf("%s%s_%09d", ITEM_PREFIX,
-		note.NoteID, itemid)
+// UntaggedItems returns items of the current note without any tag, which
+// are not reachable by tags.
+func (notedb *NoteDB) UntaggedItems() ([]*Item, error) {
+	note, err := notedb.GetCurrentNote()
+	if err != nil {
+		return nil, err
+	}
 
-	err = notedb.DeleteStruct(key)
+	items := make([]*Item, 0)
+	err = notedb.EachItem(note, func(item *Item) error {
+		if len(item.Tags) == 0 {
+			items = append(items, item)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// UpdateNoteItem saves a changed item of note, and updates the tag index of
+// note. The former version is kept as a revision.
+func (notedb *NoteDB) UpdateNoteItem(note *Note, item *Item) (err error) {
+	notedb.begin("UpdateNoteItem", noteName(note), item.ItemID)
+	defer func() { err = notedb.commit(err) }()
+
+	itemid, err := strconv.Atoi(item.ItemID)
+	if err != nil {
+		return err
+	}
+	old, err := notedb.ReadNoteItem(note, itemid)
 	if err != nil {
 		return err
 	}
 
-	// update note
-	note.Sum--
-	for _, tag := range item.Tags {
-		delete(note.Tags[tag], item.ItemID)
+	item.Updated = time.Now().Format(TIME_FORMAT)
+	err = notedb.SaveStruct(itemKey(note.NoteID, itemid), item)
+	if err != nil {
+		return err
+	}
 
-		if len(note.Tags[tag]) == 0 {
-			delete(note.Tags, tag)
+	note.unindexItem(old)
+	note.indexItem(item)
+	return notedb.SaveNote(note)
+}
+
+// PinNoteItem pins or unpins an item of note.
+func (notedb *NoteDB) PinNoteItem(note *Note, itemid int, pinned bool) (item *Item, err error) {
+	notedb.begin("PinNoteItem", noteName(note), strconv.Itoa(itemid),
+		strconv.FormatBool(pinned))
+	defer func() { err = notedb.commit(err) }()
+
+	item, err = notedb.ReadNoteItem(note, itemid)
+	if err != nil {
+		return nil, err
+	}
+	if item.Pinned == pinned {
+		return item, nil
+	}
+
+	item.Pinned = pinned
+	err = notedb.saveItemFlags(note, item)
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// saveItemFlags saves an item of note whose flags, pinned or status, are
+// changed. Unlike UpdateNoteItem, the update time is kept and no revision
+// is added.
+func (notedb *NoteDB) saveItemFlags(note *Note, item *Item) error {
+	itemid, err := strconv.Atoi(item.ItemID)
+	if err != nil {
+		return err
+	}
+	return notedb.SaveStruct(itemKey(note.NoteID, itemid), item)
+}
+
+// PinnedItems returns pinned items of note in the order of item ID.
+func (notedb *NoteDB) PinnedItems(note *Note) ([]*Item, error) {
+	items := make([]*Item, 0)
+	err := notedb.EachItem(note, func(item *Item) error {
+		if item.Pinned {
+			items = append(items, item)
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// RemoveNoteItem moves an item to the trash.
+func (notedb *NoteDB) RemoveNoteItem(note *Note, itemid int) (err error) {
+	notedb.begin("RemoveNoteItem", noteName(note), strconv.Itoa(itemid))
+	defer func() { err = notedb.commit(err) }()
+
+	err = notedb.trashNoteItem(note, itemid, false)
+	if err != nil {
+		return err
 	}
-	note.LastUpdate = now.BeginningOfMinute().String()
 
 	// save note
 	err = notedb.SaveNote(note)
@@ -390,27 +935,9 @@ func (notedb *NoteDB) ItemByRegexp(queries []string) ([]*Item, error) {
 	}
 
 	// read all items
-	if note.Items == nil {
-		note.Items = make(map[int]*Item, 0)
-	}
-	for tag, _ := range note.Tags {
-		for itemid, _ := range note.Tags[tag] {
-			itemid, err := strconv.Atoi(itemid)
-			if err != nil {
-				return nil, err
-			}
-
-			if _, ok := note.Items[itemid]; ok { // already loaded
-				continue
-			}
-
-			item, err := notedb.ReadNoteItem(note, itemid)
-			if err != nil {
-				return nil, err
-			}
-
-			note.Items[itemid] = item
-		}
+	all, err := notedb.ReadNoteItems(note)
+	if err != nil {
+		return nil, err
 	}
 
 	// query by regexp
@@ -418,16 +945,8 @@ func (notedb *NoteDB) ItemByRegexp(queries []string) ([]*Item, error) {
 	for _, query := range queries {
 		re := regexp.MustCompile(query)
 
-		// sort itemids
-		itemids := make([]int, 0)
-		for itemid, _ := range note.Items {
-			itemids = append(itemids, itemid)
-		}
-		sort.Ints(itemids)
-
-		for _, itemid := range itemids {
-			item := note.Items[itemid]
-			if re.MatchString(item.Content) {
+		for _, item := range all {
+			if !item.Secret && re.MatchString(item.Content) {
 				items = append(items, item)
 			}
 		}
@@ -445,21 +964,20 @@ func (notedb *NoteDB) ItemByTag(tags []string) ([]*Item, error) {
 	items := make([]*Item, 0)
 
 	for _, tag := range tags {
-		if _, ok := note.Tags[tag]; !ok {
+		tag = notedb.resolveTag(note, tag)
+
+		// a tag matches all its descendants, e.g. "lang" matches "lang/go"
+		subtree := subtreeTags(note, tag)
+		if len(subtree) == 0 {
 			fmt.Printf("tag \"%s\" not exist in note \"%s\".\n", tag, note.NoteID)
 			continue
 		}
 
-		// sort itemids
-		itemids := make([]int, 0)
-		for itemid, _ := range note.Tags[tag] {
-			itemid, err := strconv.Atoi(itemid)
-			if err != nil {
-				return nil, err
-			}
-			itemids = append(itemids, itemid)
+		// sorted itemids
+		itemids, err := itemsOfTags(note, subtree)
+		if err != nil {
+			return nil, err
 		}
-		sort.Ints(itemids)
 
 		for _, itemid := range itemids {
 			item, err := notedb.ReadNoteItem(note, itemid)
@@ -478,7 +996,7 @@ func (notedb *NoteDB) ItemByTag(tags []string) ([]*Item, error) {
 func (notedb *NoteDB) ReadConfig() {
 	notedb.Config = &Config{}
 
-	err := notedb.ReadStruct("config", notedb.Config)
+	err := notedb.ReadStruct(CONFIG_KEY, notedb.Config)
 	if err != nil { // no config
 		notedb.Config = &Config{CurrentNoteName: ""}
 		return
@@ -486,120 +1004,319 @@ func (notedb *NoteDB) ReadConfig() {
 }
 
 func (notedb *NoteDB) SaveConfig() {
-	err := notedb.SaveStruct("config", notedb.Config)
+	err := notedb.SaveStruct(CONFIG_KEY, notedb.Config)
 	if err != nil {
-		fmt.Sprintf("fail to save config. %v", err)
-		os.Exit(0)
+		fmt.Printf("fail to save config. %v\n", err)
+		os.Exit(1)
 	}
 }
 
 //////////////////////////////////////////////////////////////////////
 
 func (notedb *NoteDB) ReadStruct(key string, str interface{}) error {
-	data, err := notedb.db.Get([]byte(key), nil)
-	if err != nil { // not exist
+	return readStruct(notedb, key, str)
+}
+
+func (notedb *NoteDB) SaveStruct(key string, str interface{}) error {
+	bytes, err := json.Marshal(str)
+	if err != nil {
 		return err
 	}
-	if err := json.Unmarshal(data, str); err != nil {
+
+	err = notedb.put(key, bytes)
+	if err != nil {
 		return err
 	}
 
+	// fmt.Printf("save %s: %s\n", key, string(bytes))
 	return nil
 }
 
-func (notedb *NoteDB) SaveStruct(key string, str interface{}) error {
+// batchStruct adds saving str to key into batch.
+func batchStruct(batch *leveldb.Batch, key string, str interface{}) error {
 	bytes, err := json.Marshal(str)
 	if err != nil {
 		return err
 	}
 
-	err = notedb.db.Put([]byte(key), bytes, nil)
+	batch.Put([]byte(key), bytes)
+	return nil
+}
+
+func (notedb *NoteDB) DeleteStruct(key string) error {
+	err := notedb.delete(key)
 	if err != nil {
 		return err
 	}
-
-	// fmt.Printf("save %s: %s\n", key, string(bytes))
 	return nil
 }
 
-func (notedb *NoteDB) DeleteStruct(key string) error {
-	err := notedb.db.Delete([]byte(key), nil)
+// DumpFilter selects items to dump. Empty fields match all.
+type DumpFilter struct {
+	Note string
+	// items with the tag or its subtags
+	Tag string
+	// items with content matching the regular expression
+	Search string
+}
+
+// Dump writes the whole database to w, or the items selected by filter if
+// not nil, encrypted when keys are given. The dump is taken from a
+// snapshot, so it is consistent even if the database is written meanwhile.
+func (notedb *NoteDB) Dump(w io.Writer, keys *DumpKeys, filter *DumpFilter) (err error) {
+	snapshot, err := notedb.Snapshot()
 	if err != nil {
 		return err
 	}
-	return nil
+	defer snapshot.Release()
+
+	if keys != nil {
+		var encrypted io.WriteCloser
+		encrypted, err = encryptDump(w, keys)
+		if err != nil {
+			return err
+		}
+		// closing writes the last chunk of the encrypted dump
+		defer func() {
+			if e := encrypted.Close(); err == nil {
+				err = e
+			}
+		}()
+		w = encrypted
+	}
+
+	if filter != nil {
+		return notedb.dumpSelected(w, snapshot, filter)
+	}
+
+	// the empty prefix covers the whole database
+	return snapshot.ScanPrefix("", func(key, value []byte) error {
+		// local state, not data
+		if string(key) == UNDO_KEY || strings.HasPrefix(string(key), JOURNAL_PREFIX) {
+			return nil
+		}
+		_, err := fmt.Fprintf(w, "%s\t%s\r\n", key, value)
+		return err
+	})
 }
 
-func (notedb *NoteDB) Dump() error {
-	iter := notedb.db.NewIterator(nil, nil)
-	for iter.Next() {
-		key := iter.Key()
-		value := iter.Value()
-		fmt.Printf("%s\t%s\r\n", key, value)
+// dumpSelected writes the items selected by filter, and their notes with
+// the index rebuilt for just those items. Config, history, trash and
+// journal are not dumped.
+func (notedb *NoteDB) dumpSelected(w io.Writer, snapshot *Snapshot, filter *DumpFilter) error {
+	var re *regexp.Regexp
+	if filter.Search != "" {
+		var err error
+		re, err = regexp.Compile(filter.Search)
+		if err != nil {
+			return errors.New(fmt.Sprintf("invalid regular expression \"%s\". %v",
+				filter.Search, err))
+		}
+	}
+
+	notenames := notedb.NotesList
+	if filter.Note != "" {
+		notenames = []string{filter.Note}
+	}
+
+	lines := make(map[string][]byte, 0)
+	for _, notename := range notenames {
+		note, err := snapshot.ReadNote(notename)
+		if err != nil {
+			return err
+		}
+		items, err := snapshot.ReadNoteItems(notename)
+		if err != nil {
+			return err
+		}
+
+		tag := ""
+		if filter.Tag != "" {
+			tag = notedb.resolveTag(note, filter.Tag)
+		}
+
+		// the note keeps its name, last ID and aliases
+		selected := *note
+		selected.Sum = 0
+		selected.Tags = map[string]map[string]bool{}
+		for _, item := range items {
+			if tag != "" {
+				tagged := false
+				for _, t := range item.Tags {
+					if isSubtag(t, tag) {
+						tagged = true
+						break
+					}
+				}
+				if !tagged {
+					continue
+				}
+			}
+			if re != nil && (item.Secret || !re.MatchString(item.Content)) {
+				continue
+			}
+
+			selected.indexItem(item)
+			itemid, err := strconv.Atoi(item.ItemID)
+			if err != nil {
+				return err
+			}
+			lines[itemKey(notename, itemid)], err = json.Marshal(item)
+			if err != nil {
+				return err
+			}
+		}
+
+		// notes without selected items are left out, unless asked for
+		if selected.Sum == 0 && filter.Note == "" {
+			continue
+		}
+		selected.LastUpdate = note.LastUpdate
+		lines[noteKey(notename)], err = json.Marshal(selected)
+		if err != nil {
+			return err
+		}
+	}
+
+	keys := make([]string, 0, len(lines))
+	for key, _ := range lines {
+		keys = append(keys, key)
 	}
-	iter.Release()
-	err := iter.Error()
-	return err
+	sort.Strings(keys)
+	for _, key := range keys {
+		_, err := fmt.Fprintf(w, "%s\t%s\r\n", key, lines[key])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (notedb *NoteDB) Wipe() error {
-	for _, notename := range notedb.NotesList {
-		err := notedb.DeleteNote(notename)
+// Wipe deletes all notes, items, their history and the trash.
+func (notedb *NoteDB) Wipe() (err error) {
+	err = notedb.backupBefore("wipe")
+	if err != nil {
+		return err
+	}
+
+	notedb.begin("Wipe")
+	defer func() { err = notedb.commit(err) }()
+
+	for _, prefix := range []string{NOTE_PREFIX, ITEM_PREFIX, HISTORY_PREFIX, TRASH_PREFIX} {
+		err = notedb.ScanPrefix(prefix, func(key, value []byte) error {
+			return notedb.delete(string(key))
+		})
 		if err != nil {
 			return err
 		}
 	}
+
+	notedb.NotesList = make([]string, 0)
+	notedb.Config.CurrentNoteName = ""
+	notedb.CurrentNote = nil
+
 	return nil
 }
 
-func (notedb *NoteDB) Restore(filename string) error {
-	// wipe all the database
-	err := notedb.Wipe()
+// Restore replaces the database with a dump or a backup archive. Encrypted
+// dumps are decrypted with keys, and backup archives are verified before
+// the database is touched.
+func (notedb *NoteDB) Restore(filename string, keys *DumpKeys) (err error) {
+	// read before the automatic backup, which may remove filename when it
+	// is the oldest backup, see funRollback
+	data, err := readDump(filename, keys)
 	if err != nil {
 		return err
 	}
 
-	fh, err := os.Open(filename)
+	err = notedb.backupBefore("restore")
 	if err != nil {
-		return errors.New("fail to open file: " + filename)
+		return err
 	}
 
-	batch := new(leveldb.Batch)
-	reader := bufio.NewReader(fh)
+	notedb.begin("Restore", filename)
+	defer func() { err = notedb.commit(err) }()
+
+	// replacing the whole database is not an edit of items
+	notedb.tx.noHistory = true
+
+	// wipe all the database
+	err = notedb.Wipe()
+	if err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(bytes.NewReader(data))
 	re1 := regexp.MustCompile(`[\r\n]`)
 	re2 := regexp.MustCompile(`^\s+|\s+$`)
 	re := regexp.MustCompile(`([^\t]+)\t([^\t]+)`)
+	restore := func(str string) {
+		if !re.MatchString(str) {
+			return
+		}
+		data := re.FindSubmatch([]byte(str))
+		key := string(data[1])
+		// the journal of this database is kept, and dumps of older versions
+		// may contain the journal of another one
+		if key == UNDO_KEY || strings.HasPrefix(key, JOURNAL_PREFIX) {
+			return
+		}
+		notedb.put(key, data[2])
+	}
 	for {
 		str, err := reader.ReadString('\n')
 
 		str = re1.ReplaceAllString(str, "")
 		str = re2.ReplaceAllString(str, "")
+		restore(str)
 		if err == io.EOF {
-
-			if re.MatchString(str) {
-				data := re.FindSubmatch([]byte(str))
-				batch.Put(data[1], data[2])
-			}
-
 			break
 		}
+	}
 
-		if re.MatchString(str) {
-			data := re.FindSubmatch([]byte(str))
-			batch.Put(data[1], data[2])
+	// take the restored config
+	notedb.ReadConfig()
+
+	return nil
+}
+
+// readDump reads a dump or a backup archive, decrypted if it is encrypted.
+func readDump(filename string, keys *DumpKeys) ([]byte, error) {
+	fh, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.New("fail to open file: " + filename)
+	}
+	defer fh.Close()
+
+	reader := bufio.NewReader(fh)
+	if isEncryptedDump(reader) {
+		if keys == nil {
+			return nil, errors.New("the dump is encrypted, use --decrypt or --identity.")
 		}
+		decrypted, err := decryptDump(reader, keys)
+		if err != nil {
+			return nil, err
+		}
+		reader = bufio.NewReader(decrypted)
 	}
 
-	err = notedb.db.Write(batch, nil)
+	if isBackup(reader) {
+		_, data, err := readBackup(reader)
+		return data, err
+	}
+	return ioutil.ReadAll(reader)
+}
+
+func (notedb *NoteDB) Import(notename, othernotename, filename string) (n int, err error) {
+	err = notedb.backupBefore("import")
 	if err != nil {
-		return nil
+		return 0, err
 	}
 
-	return nil
-}
+	notedb.begin("Import", notename, othernotename, filename)
+	defer func() { err = notedb.commit(err) }()
 
-func (notedb *NoteDB) Import(notename, othernotename, filename string) (int, error) {
-	err := notedb.UseNote(notename)
+	err = notedb.UseNote(notename)
 	if err != nil {
 		return 0, err
 	}
@@ -608,15 +1325,13 @@ func (notedb *NoteDB) Import(notename, othernotename, filename string) (int, err
 	if err != nil {
 		return 0, errors.New("fail to open file: " + filename)
 	}
+	defer fh.Close()
 
-	batch := new(leveldb.Batch)
 	reader := bufio.NewReader(fh)
 	re1 := regexp.MustCompile(`[\r\n]`)
 	re2 := regexp.MustCompile(`^\s+|\s+$`)
 	re := regexp.MustCompile(`([^\t]+)\t([^\t]+)`)
 
-	n := 0
-
 	for {
 		str, err := reader.ReadString('\n')
 
@@ -632,12 +1347,8 @@ func (notedb *NoteDB) Import(notename, othernotename, filename string) (int, err
 			key := string(data[1])
 			value := data[2]
 
-			if !strings.HasPrefix(key, ITEM_PREFIX) {
-				break
-			}
-
-			key = trim_prefix(ITEM_PREFIX, key)
-			if !strings.HasPrefix(key, othernotename) {
+			if !strings.HasPrefix(key, itemPrefix(othernotename)) ||
+				!keyOfNote([]byte(key), itemPrefix(othernotename), 1) {
 				break
 			}
 
@@ -647,7 +1358,8 @@ func (notedb *NoteDB) Import(notename, othernotename, filename string) (int, err
 			}
 
 			_, err = notedb.AddNoteItem(
-				strings.Join(item.Tags, ","), item.Content)
+				strings.Join(item.Tags, TAG_SEPARATOR), item.Content,
+				itemOptions(item))
 			if err != nil {
 				return 0, err
 			}
@@ -663,12 +1375,8 @@ func (notedb *NoteDB) Import(notename, othernotename, filename string) (int, err
 		data := re.FindSubmatch([]byte(str))
 		key := string(data[1])
 		value := data[2]
-		if !strings.HasPrefix(key, ITEM_PREFIX) {
-			continue
-		}
-
-		key = trim_prefix(ITEM_PREFIX, key)
-		if !strings.HasPrefix(key, othernotename) {
+		if !strings.HasPrefix(key, itemPrefix(othernotename)) ||
+			!keyOfNote([]byte(key), itemPrefix(othernotename), 1) {
 			continue
 		}
 
@@ -678,7 +1386,8 @@ func (notedb *NoteDB) Import(notename, othernotename, filename string) (int, err
 		}
 
 		_, err = notedb.AddNoteItem(
-			strings.Join(item.Tags, ","), item.Content)
+			strings.Join(item.Tags, TAG_SEPARATOR), item.Content,
+			itemOptions(item))
 		if err != nil {
 			return 0, err
 		}
@@ -686,10 +1395,5 @@ func (notedb *NoteDB) Import(notename, othernotename, filename string) (int, err
 		n++
 	}
 
-	err = notedb.db.Write(batch, nil)
-	if err != nil {
-		return 0, err
-	}
-
 	return n, nil
 }
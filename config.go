@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Setting is an option of cnote saved in the config of database.
+type Setting struct {
+	Default string
+	Usage   string
+	// check the value, nil for any value
+	Check func(value string) error
+}
+
+func checkBool(value string) error {
+	_, err := strconv.ParseBool(value)
+	if err != nil {
+		return errors.New(fmt.Sprintf("invalid value \"%s\", should be true or false.", value))
+	}
+	return nil
+}
+
+func checkCount(value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return errors.New(fmt.Sprintf("invalid value \"%s\", should be non-negative integer.", value))
+	}
+	return nil
+}
+
+var SETTINGS = map[string]*Setting{
+	"tag.trim": &Setting{
+		Default: "true",
+		Usage:   "Remove leading and trailing spaces of tags",
+		Check:   checkBool,
+	},
+	"tag.lowercase": &Setting{
+		Default: "false",
+		Usage:   "Convert tags to lower case",
+		Check:   checkBool,
+	},
+	"tag.nfc": &Setting{
+		Default: "true",
+		Usage:   "Convert tags to Unicode normalization form C",
+		Check:   checkBool,
+	},
+	"backup.auto": &Setting{
+		Default: "false",
+		Usage:   "Back up the database before del, wipe, restore and import",
+		Check:   checkBool,
+	},
+	"backup.every": &Setting{
+		Default: "0",
+		Usage:   "Back up the database every N writes, 0 for never",
+		Check:   checkCount,
+	},
+	"backup.keep": &Setting{
+		Default: "10",
+		Usage:   "Number of automatic backups kept",
+		Check:   checkCount,
+	},
+}
+
+// SettingNames returns the names of all settings, sorted.
+func SettingNames() []string {
+	names := make([]string, 0, len(SETTINGS))
+	for name, _ := range SETTINGS {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get returns the value of a setting.
+func (conf *Config) Get(name string) string {
+	if value, ok := conf.Settings[name]; ok {
+		return value
+	}
+	if setting, ok := SETTINGS[name]; ok {
+		return setting.Default
+	}
+	return ""
+}
+
+func (conf *Config) GetBool(name string) bool {
+	value, _ := strconv.ParseBool(conf.Get(name))
+	return value
+}
+
+func (conf *Config) GetInt(name string) int {
+	value, _ := strconv.Atoi(conf.Get(name))
+	return value
+}
+
+// Set changes a setting. Setting the default value removes it from config.
+func (conf *Config) Set(name, value string) error {
+	setting, ok := SETTINGS[name]
+	if !ok {
+		return errors.New(fmt.Sprintf("unknown setting \"%s\".", name))
+	}
+	if setting.Check != nil {
+		err := setting.Check(value)
+		if err != nil {
+			return err
+		}
+	}
+
+	if value == setting.Default {
+		delete(conf.Settings, name)
+		return nil
+	}
+	if conf.Settings == nil {
+		conf.Settings = make(map[string]string)
+	}
+	conf.Settings[name] = value
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/now"
+)
+
+// DueItem is an item with due date, with the note it belongs to.
+type DueItem struct {
+	Note string
+	Item *Item
+	Due  time.Time
+}
+
+func (dueitem *DueItem) String() string {
+	return fmt.Sprintf("%s\t(note: %s, due: %s)",
+		dueitem.Item.String(), dueitem.Note, dueitem.Item.Due)
+}
+
+// parseDue parses a due date in the future, given as a duration after now
+// like "2h" or "3d", as "today", "tomorrow", "friday" or "next friday"
+// (the end of the day), or as a time like "2014-07-20 10:00".
+func parseDue(s string) (time.Time, error) {
+	word := strings.ToLower(strings.TrimSpace(s))
+	switch word {
+	case "today":
+		return now.EndOfDay(), nil
+	case "tomorrow":
+		return now.New(time.Now().AddDate(0, 0, 1)).EndOfDay(), nil
+	}
+
+	// the next weekday after today
+	word = strings.TrimPrefix(word, "next ")
+	for d := 1; d <= 7; d++ {
+		day := time.Now().AddDate(0, 0, d)
+		if strings.ToLower(day.Weekday().String()) == word {
+			return now.New(day).EndOfDay(), nil
+		}
+	}
+
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err == nil {
+			return time.Now().AddDate(0, 0, days), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(d), nil
+	}
+
+	t, err := now.Parse(s)
+	if err != nil {
+		return t, errors.New(fmt.Sprintf("invalid due date \"%s\".", s))
+	}
+	return t, nil
+}
+
+// DueItems returns items of all notes due before the given time, which
+// are not done, sorted by due date. The zero time means no limit. Notes are
+// read from one snapshot of the database.
+func (notedb *NoteDB) DueItems(before time.Time) ([]*DueItem, error) {
+	snapshot, err := notedb.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	defer snapshot.Release()
+
+	dueitems := make([]*DueItem, 0)
+	for _, notename := range notedb.NotesList {
+		items, err := snapshot.ReadNoteItems(notename)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range items {
+			if item.Due == "" || item.Status == ITEM_DONE {
+				continue
+			}
+
+			due := parseTimestamp(item.Due)
+			if !before.IsZero() && due.After(before) {
+				continue
+			}
+			dueitems = append(dueitems, &DueItem{notename, item, due})
+		}
+	}
+
+	sort.SliceStable(dueitems, func(i, j int) bool {
+		return dueitems[i].Due.Before(dueitems[j].Due)
+	})
+	return dueitems, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+)
+
+// A note dumped and imported into another note keeps all properties of
+// its items.
+func TestDumpImportNote(t *testing.T) {
+	notedb, close := newTestDB(t)
+	defer close()
+
+	due, err := time.Parse(TIME_FORMAT, "2014-07-25 23:59:59 +0800 CST")
+	if err != nil {
+		t.Fatal(err)
+	}
+	item, err := notedb.AddNoteItem("db", "main database", &ItemOptions{
+		Due:    due,
+		Fields: map[string]string{"host": "db1", "port": "5432"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = notedb.PinNoteItem(notedb.CurrentNote, 1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item, err = notedb.SetItemStatus(notedb.CurrentNote, 1, ITEM_DONE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fh, err := ioutil.TempFile("", "cnote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fh.Name())
+	err = notedb.Dump(fh, nil, &DumpFilter{Note: "fruit"})
+	fh.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = notedb.NewNote("copy", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := notedb.Import("copy", "fruit", fh.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 item imported, got %d", n)
+	}
+
+	imported, err := notedb.ReadNoteItem(notedb.CurrentNote, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported.Content != item.Content || !reflect.DeepEqual(imported.Tags, item.Tags) ||
+		imported.Due != item.Due || !reflect.DeepEqual(imported.Fields, item.Fields) ||
+		imported.Status != item.Status || imported.Pinned != item.Pinned {
+		t.Errorf("expected\n%s\ngot\n%s", item.Details("fruit"), imported.Details("copy"))
+	}
+}
+
+// failingWriter fails after n bytes are written.
+type failingWriter struct {
+	n int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		n := w.n
+		w.n = 0
+		return n, errors.New("disk full")
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestEncryptedDumpWriteError(t *testing.T) {
+	notedb, close := newTestDB(t, "apple", "pear", "banana")
+	defer close()
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := &DumpKeys{Recipients: []string{identity.Recipient().String()}}
+
+	// the header is written, but not the encrypted dump
+	err = notedb.Dump(&failingWriter{n: 288}, keys, nil)
+	if err == nil {
+		t.Error("expected the error of writing the encrypted dump")
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+// DumpKeys are the keys of encrypted dumps, in the format of age
+// (https://age-encryption.org). Dumps are encrypted with a passphrase, or
+// to X25519 public keys ("age1...") and decrypted with the secret keys.
+type DumpKeys struct {
+	Passphrase string
+	// public keys, for encrypting
+	Recipients []string
+	// file of secret keys, like the one of age-keygen, for decrypting
+	IdentityFile string
+}
+
+// headers of encrypted dumps, armored or not
+var (
+	AGE_HEADER       = []byte("age-encryption.org/")
+	AGE_ARMOR_HEADER = []byte(armor.Header)
+)
+
+// encryptDump returns a writer encrypting to w, which must be closed to
+// flush the encrypted dump. The dump is armored as text.
+func encryptDump(w io.Writer, keys *DumpKeys) (io.WriteCloser, error) {
+	recipients := make([]age.Recipient, 0)
+	if keys.Passphrase != "" {
+		recipient, err := age.NewScryptRecipient(keys.Passphrase)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, recipient)
+	}
+	for _, key := range keys.Recipients {
+		recipient, err := age.ParseX25519Recipient(key)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("invalid recipient \"%s\". %v", key, err))
+		}
+		recipients = append(recipients, recipient)
+	}
+	if len(recipients) == 0 {
+		return nil, errors.New("passphrase or recipient needed to encrypt.")
+	}
+
+	armored := armor.NewWriter(w)
+	encrypted, err := age.Encrypt(armored, recipients...)
+	if err != nil {
+		return nil, err
+	}
+	return &dumpWriter{encrypted, armored}, nil
+}
+
+// dumpWriter closes the encrypting writer and then the armor.
+type dumpWriter struct {
+	io.WriteCloser
+	armored io.WriteCloser
+}
+
+func (w *dumpWriter) Close() error {
+	err := w.WriteCloser.Close()
+	if err != nil {
+		return err
+	}
+	return w.armored.Close()
+}
+
+// isEncryptedDump reports whether the dump read by r is encrypted.
+func isEncryptedDump(r *bufio.Reader) bool {
+	head, _ := r.Peek(len(AGE_ARMOR_HEADER))
+	return bytes.HasPrefix(head, AGE_HEADER) || bytes.HasPrefix(head, AGE_ARMOR_HEADER)
+}
+
+// decryptDump returns a reader of the dump decrypted from r, armored or
+// not.
+func decryptDump(r *bufio.Reader, keys *DumpKeys) (io.Reader, error) {
+	identities := make([]age.Identity, 0)
+	if keys.Passphrase != "" {
+		identity, err := age.NewScryptIdentity(keys.Passphrase)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	if keys.IdentityFile != "" {
+		fh, err := os.Open(keys.IdentityFile)
+		if err != nil {
+			return nil, errors.New("fail to open file: " + keys.IdentityFile)
+		}
+		defer fh.Close()
+
+		ids, err := age.ParseIdentities(fh)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("fail to read identities in %s. %v",
+				keys.IdentityFile, err))
+		}
+		identities = append(identities, ids...)
+	}
+	if len(identities) == 0 {
+		return nil, errors.New("passphrase or identity needed to decrypt.")
+	}
+
+	var src io.Reader = r
+	head, _ := r.Peek(len(AGE_ARMOR_HEADER))
+	if bytes.HasPrefix(head, AGE_ARMOR_HEADER) {
+		src = armor.NewReader(r)
+	}
+
+	decrypted, err := age.Decrypt(src, identities...)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("fail to decrypt the dump. %v", err))
+	}
+	return decrypted, nil
+}
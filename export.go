@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// formats of exported items
+var EXPORT_FORMATS = map[string]rune{
+	"csv": ',',
+	"tsv": '\t',
+}
+
+// ITEM_COLUMNS are the columns of exported items, followed by one column
+// for every field key.
+var ITEM_COLUMNS = []string{"id", "tags", "content", "status", "due",
+	"created", "updated"}
+
+// WriteItems writes items as CSV or TSV with a header line.
+func WriteItems(w io.Writer, items []*Item, format string) error {
+	comma, ok := EXPORT_FORMATS[format]
+	if !ok {
+		return errors.New(fmt.Sprintf("unknown format \"%s\", should be csv or tsv.",
+			format))
+	}
+
+	// union of field keys
+	keyset := make(map[string]bool, 0)
+	for _, item := range items {
+		for key, _ := range item.Fields {
+			keyset[key] = true
+		}
+	}
+	keys := make([]string, 0, len(keyset))
+	for key, _ := range keyset {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	writer := csv.NewWriter(w)
+	writer.Comma = comma
+
+	err := writer.Write(append(append([]string{}, ITEM_COLUMNS...), keys...))
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		record := []string{item.ItemID, strings.Join(item.Tags, TAG_SEPARATOR),
+			item.ShownContent(), item.Status, item.Due, item.Created, item.Updated}
+		for _, key := range keys {
+			record = append(record, item.Fields[key])
+		}
+
+		err = writer.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
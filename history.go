@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// Revision is a former version of an item, saved when the item is changed.
+type Revision struct {
+	Rev int `json:"rev"`
+	// when this version was replaced
+	Time string `json:"time"`
+	Item Item   `json:"item"`
+}
+
+func (rev *Revision) String() string {
+	return fmt.Sprintf("r%d\t(replaced at: %s)\t(tags: %v)\t%s",
+		rev.Rev, rev.Time, rev.Item.Tags, rev.Item.ShownContent())
+}
+
+// addRevisions adds a revision to tx for every item overwritten in tx.
+func (notedb *NoteDB) addRevisions(tx *txn) error {
+	if tx.noHistory {
+		return nil
+	}
+
+	for key, value := range tx.writes {
+		if value == nil || !strings.HasPrefix(key, ITEM_PREFIX) {
+			continue
+		}
+
+		old, err := notedb.db.Get([]byte(key), nil)
+		if err == leveldb.ErrNotFound { // new item
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(old, value) || flagsOnly(old, value) {
+			continue
+		}
+
+		notename, itemid, ok := parseItemKey(key)
+		if !ok {
+			continue
+		}
+
+		rev := &Revision{Time: time.Now().Format(TIME_FORMAT)}
+		err = json.Unmarshal(old, &rev.Item)
+		if err != nil {
+			return err
+		}
+
+		// revisions are numbered from 1
+		rev.Rev = 1
+		err = notedb.ScanPrefix(historyPrefix(notename, itemid), func(key, value []byte) error {
+			rev.Rev++
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(rev)
+		if err != nil {
+			return err
+		}
+		tx.writes[historyKey(notename, itemid, rev.Rev)] = data
+	}
+	return nil
+}
+
+// flagsOnly reports whether two versions of an item differ only in flags,
+// pinned and status, whose changes are not kept as revisions.
+func flagsOnly(old, value []byte) bool {
+	var a, b Item
+	if json.Unmarshal(old, &a) != nil || json.Unmarshal(value, &b) != nil {
+		return false
+	}
+	a.Pinned, b.Pinned = false, false
+	a.Status, b.Status = "", ""
+
+	dataA, errA := json.Marshal(&a)
+	dataB, errB := json.Marshal(&b)
+	return errA == nil && errB == nil && bytes.Equal(dataA, dataB)
+}
+
+// ItemHistory returns all revisions of an item of note, oldest first.
+func (notedb *NoteDB) ItemHistory(note *Note, itemid int) ([]*Revision, error) {
+	if note == nil {
+		return nil, notedb.noNoteError()
+	}
+
+	revs := make([]*Revision, 0)
+	err := notedb.ScanPrefix(historyPrefix(note.NoteID, itemid), func(key, value []byte) error {
+		rev := &Revision{}
+		err := json.Unmarshal(value, rev)
+		if err != nil {
+			return err
+		}
+		revs = append(revs, rev)
+		return nil
+	})
+	return revs, err
+}
+
+// ReadRevision returns revision rev of an item of note.
+func (notedb *NoteDB) ReadRevision(note *Note, itemid int, rev int) (*Revision, error) {
+	if note == nil {
+		return nil, notedb.noNoteError()
+	}
+
+	revision := &Revision{}
+	err := notedb.ReadStruct(historyKey(note.NoteID, itemid, rev), revision)
+	if err != nil {
+		return nil, errors.New(
+			fmt.Sprintf("revision \"r%d\" not exist for item \"%d\".", rev, itemid))
+	}
+	return revision, nil
+}
+
+// RevertNoteItem changes an item back to revision rev. The replaced
+// version becomes a new revision.
+func (notedb *NoteDB) RevertNoteItem(note *Note, itemid int, rev int) (item *Item, err error) {
+	notedb.begin("RevertNoteItem", noteName(note), strconv.Itoa(itemid),
+		fmt.Sprintf("r%d", rev))
+	defer func() { err = notedb.commit(err) }()
+
+	item, err = notedb.ReadNoteItem(note, itemid)
+	if err != nil {
+		return nil, err
+	}
+	revision, err := notedb.ReadRevision(note, itemid, rev)
+	if err != nil {
+		return nil, err
+	}
+
+	reverted := revision.Item
+	reverted.ItemID = item.ItemID
+	// flags are not kept in revisions, see flagsOnly
+	reverted.Pinned = item.Pinned
+	reverted.Status = item.Status
+	err = notedb.UpdateNoteItem(note, &reverted)
+	if err != nil {
+		return nil, err
+	}
+
+	return &reverted, nil
+}
+
+// copyHistory copies the revisions of an item to another item, and deletes
+// the original ones if move is true.
+func (notedb *NoteDB) copyHistory(note *Note, itemid int,
+	dest *Note, destid int, move bool) error {
+
+	return notedb.ScanPrefix(historyPrefix(note.NoteID, itemid), func(key, value []byte) error {
+		rev := &Revision{}
+		err := json.Unmarshal(value, rev)
+		if err != nil {
+			return err
+		}
+
+		err = notedb.SaveStruct(historyKey(dest.NoteID, destid, rev.Rev), rev)
+		if err != nil {
+			return err
+		}
+		if move {
+			return notedb.delete(string(key))
+		}
+		return nil
+	})
+}
+
+// ParseRevision parses a revision like "r3" or "3".
+func ParseRevision(s string) (int, error) {
+	rev, err := strconv.Atoi(strings.TrimPrefix(s, "r"))
+	if err != nil || rev <= 0 {
+		return 0, errors.New(
+			fmt.Sprintf("invalid revision \"%s\", should be like \"r1\".", s))
+	}
+	return rev, nil
+}
+
+// DiffItems describes the differences between two versions of an item.
+func DiffItems(a, b *Item) string {
+	lines := make([]string, 0)
+
+	removed := make([]string, 0)
+	added := make([]string, 0)
+	for _, tag := range a.Tags {
+		if !stringInSlice(tag, b.Tags) {
+			removed = append(removed, "-"+tag)
+		}
+	}
+	for _, tag := range b.Tags {
+		if !stringInSlice(tag, a.Tags) {
+			added = append(added, "+"+tag)
+		}
+	}
+	if len(removed)+len(added) > 0 {
+		lines = append(lines, fmt.Sprintf("tags: %s",
+			strings.Join(append(removed, added...), " ")))
+	}
+
+	if a.Content != b.Content {
+		lines = append(lines, "- "+a.ShownContent(), "+ "+b.ShownContent())
+	}
+
+	if len(lines) == 0 {
+		return "no difference."
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// formats of files to import items from, besides dumps
+var IMPORT_FORMATS = []string{"csv", "tsv", "jsonl", "txt"}
+
+// ImportOptions tell how to take items from files of IMPORT_FORMATS.
+type ImportOptions struct {
+	Format string
+	// columns of tags and content in csv and tsv, from 1
+	TagsCol    int
+	ContentCol int
+	// the first line of csv and tsv is a header
+	Header bool
+	// keys of tags and content in jsonl
+	TagsField    string
+	ContentField string
+	// tags of items without tags, and of all lines of txt
+	Tag string
+}
+
+// ImportError is an error of a line of the imported file, which is
+// skipped.
+type ImportError struct {
+	Line int
+	Err  error
+}
+
+func (e *ImportError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+// importRecord is an item read from a line.
+type importRecord struct {
+	line    int
+	tags    string
+	content string
+	err     error
+}
+
+// ImportFrom adds items read from a csv, tsv, jsonl or txt file to note.
+// Lines failed to read are skipped and reported, other items are added as
+// one operation. The number of added items is returned.
+func (notedb *NoteDB) ImportFrom(notename, filename string, opts *ImportOptions) (n int, lineErrors []*ImportError, err error) {
+	if !stringInSlice(opts.Format, IMPORT_FORMATS) {
+		return 0, nil, errors.New(fmt.Sprintf("unknown format \"%s\", should be one of %v.",
+			opts.Format, IMPORT_FORMATS))
+	}
+
+	fh, err := os.Open(filename)
+	if err != nil {
+		return 0, nil, errors.New("fail to open file: " + filename)
+	}
+	defer fh.Close()
+
+	var records []*importRecord
+	switch opts.Format {
+	case "csv", "tsv":
+		records = readTable(fh, opts)
+	case "jsonl":
+		records, err = readJSONLines(fh, opts)
+	case "txt":
+		records, err = readTextLines(fh, opts)
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+
+	err = notedb.backupBefore("import")
+	if err != nil {
+		return 0, nil, err
+	}
+
+	notedb.begin("ImportFrom", notename, opts.Format, filename)
+	defer func() { err = notedb.commit(err) }()
+
+	err = notedb.UseNote(notename)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	lineErrors = make([]*ImportError, 0)
+	for _, record := range records {
+		if record.err == nil {
+			record.err = notedb.checkImportRecord(record, opts)
+		}
+		if record.err != nil {
+			lineErrors = append(lineErrors, &ImportError{record.line, record.err})
+			continue
+		}
+
+		_, err = notedb.AddNoteItem(record.tags, record.content, nil)
+		if err != nil {
+			return 0, nil, err
+		}
+		n++
+	}
+	return n, lineErrors, nil
+}
+
+// checkImportRecord checks the tags and content of a record before adding
+// it, so that a bad line does not abort the whole import.
+func (notedb *NoteDB) checkImportRecord(record *importRecord, opts *ImportOptions) error {
+	if strings.TrimSpace(record.content) == "" {
+		return errors.New("empty content.")
+	}
+	if strings.TrimSpace(record.tags) == "" {
+		record.tags = opts.Tag
+	}
+
+	tags := notedb.resolveTags(notedb.CurrentNote, strings.Split(record.tags, TAG_SEPARATOR))
+	for _, tag := range tags {
+		err := CheckTag(tag)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readTable reads records from csv or tsv.
+func readTable(r io.Reader, opts *ImportOptions) []*importRecord {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	if opts.Format == "tsv" {
+		reader.Comma = '\t'
+		reader.LazyQuotes = true
+	}
+
+	records := make([]*importRecord, 0)
+	first := true
+	for {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			line := 0
+			if e, ok := err.(*csv.ParseError); ok {
+				line = e.Line
+			}
+			records = append(records, &importRecord{line: line, err: err})
+			if line == 0 { // not recoverable
+				break
+			}
+			continue
+		}
+		line, _ := reader.FieldPos(0)
+
+		if first && opts.Header {
+			first = false
+			continue
+		}
+		first = false
+
+		record := &importRecord{line: line}
+		switch {
+		case opts.ContentCol < 1 || opts.ContentCol > len(fields):
+			record.err = errors.New(fmt.Sprintf("no column %d of content.", opts.ContentCol))
+		case opts.TagsCol > len(fields):
+			record.err = errors.New(fmt.Sprintf("no column %d of tags.", opts.TagsCol))
+		default:
+			record.content = fields[opts.ContentCol-1]
+			if opts.TagsCol > 0 {
+				record.tags = fields[opts.TagsCol-1]
+			}
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// readJSONLines reads records from JSON objects, one per line. Tags are
+// given as an array or as a string separated by TAG_SEPARATOR.
+func readJSONLines(r io.Reader, opts *ImportOptions) ([]*importRecord, error) {
+	records := make([]*importRecord, 0)
+	err := eachLine(r, func(line int, text string) {
+		if strings.TrimSpace(text) == "" {
+			return
+		}
+		record := &importRecord{line: line}
+		records = append(records, record)
+
+		var object map[string]interface{}
+		err := json.Unmarshal([]byte(text), &object)
+		if err != nil {
+			record.err = errors.New(fmt.Sprintf("invalid JSON. %v", err))
+			return
+		}
+
+		content, ok := object[opts.ContentField].(string)
+		if !ok {
+			record.err = errors.New(fmt.Sprintf("no string field \"%s\" of content.",
+				opts.ContentField))
+			return
+		}
+		record.content = content
+
+		switch tags := object[opts.TagsField].(type) {
+		case nil:
+		case string:
+			record.tags = tags
+		case []interface{}:
+			list := make([]string, 0, len(tags))
+			for _, tag := range tags {
+				t, ok := tag.(string)
+				if !ok {
+					record.err = errors.New(fmt.Sprintf("invalid tag %v.", tag))
+					return
+				}
+				list = append(list, t)
+			}
+			record.tags = strings.Join(list, TAG_SEPARATOR)
+		default:
+			record.err = errors.New(fmt.Sprintf("invalid field \"%s\" of tags.", opts.TagsField))
+		}
+	})
+	return records, err
+}
+
+// readTextLines reads records of non-empty lines, tagged opts.Tag.
+func readTextLines(r io.Reader, opts *ImportOptions) ([]*importRecord, error) {
+	records := make([]*importRecord, 0)
+	err := eachLine(r, func(line int, text string) {
+		if strings.TrimSpace(text) == "" {
+			return
+		}
+		records = append(records, &importRecord{line: line, tags: opts.Tag, content: text})
+	})
+	return records, err
+}
+
+// eachLine calls fn for every line of r, numbered from 1.
+func eachLine(r io.Reader, fn func(line int, text string)) error {
+	reader := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		text, err := reader.ReadString('\n')
+		if text != "" {
+			fn(line, strings.TrimRight(text, "\r\n"))
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
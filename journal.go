@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// JournalEntry records a mutating operation of NoteDB. The journal is
+// append-only, it is neither undone nor wiped.
+type JournalEntry struct {
+	Time string   `json:"time"`
+	User string   `json:"user"`
+	Op   string   `json:"op"`
+	Args []string `json:"args"`
+}
+
+func (entry *JournalEntry) String() string {
+	call := []string{entry.Op}
+	for _, arg := range entry.Args {
+		call = append(call, fmt.Sprintf("%q", arg))
+	}
+	return fmt.Sprintf("%s\t%s\t%s",
+		entry.Time, entry.User, strings.Join(call, " "))
+}
+
+// journal adds a journal entry of operation op to batch.
+func (notedb *NoteDB) journal(batch *leveldb.Batch, op string, args ...string) error {
+	t := time.Now()
+	entry := &JournalEntry{
+		Time: t.Format(TIME_FORMAT),
+		User: currentUser(),
+		Op:   op,
+		Args: args,
+	}
+	return batchStruct(batch, journalKey(t), entry)
+}
+
+// Journal returns the journal entries since the given time, oldest first.
+func (notedb *NoteDB) Journal(since time.Time) ([]*JournalEntry, error) {
+	start := JOURNAL_PREFIX
+	if !since.IsZero() {
+		start = journalKey(since)
+	}
+
+	entries := make([]*JournalEntry, 0)
+	err := notedb.ScanPrefix(JOURNAL_PREFIX, func(key, value []byte) error {
+		if string(key) < start {
+			return nil
+		}
+
+		entry := &JournalEntry{}
+		err := json.Unmarshal(value, entry)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, err
+}
+
+func currentUser() string {
+	usr, err := user.Current()
+	if err == nil {
+		return usr.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return os.Getenv("USERNAME")
+}
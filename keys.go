@@ -0,0 +1,203 @@
+package main
+
+// Key schema of the database. Every key is built by the functions below, and
+// every key space is scanned through ScanPrefix with one of these prefixes.
+//
+//   config                  Config
+//   note_<note>             Note
+//   item_<note>_<id>        Item, id is zero-padded to 9 digits
+//   trash_note_<note>       TrashNote, a deleted note
+//   trash_item_<note>_<id>  TrashItem, a removed item
+//   trash_hist_<note>_<id>_<rev>
+//                           Revision of a removed item
+//   hist_<note>_<id>_<rev>  Revision, a former version of a living item
+//   undo                    Undo, record of the last mutating operation
+//   journal_<time>          JournalEntry, time is in nanoseconds, 20 digits
+//
+// Note names never contain "_" (see CheckNoteName), so the prefix of one
+// note is never a prefix of the keys of another note. Notes created before
+// this rule may still contain "_", so scans of a note check the keys with
+// keyOfNote too.
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+var (
+	CONFIG_KEY  = "config"
+	NOTE_PREFIX = "note_"
+	ITEM_PREFIX = "item_"
+
+	TRASH_PREFIX      = "trash_"
+	TRASH_NOTE_PREFIX = TRASH_PREFIX + "note_"
+	TRASH_ITEM_PREFIX = TRASH_PREFIX + "item_"
+	// revisions of removed items, moved back when the items are restored
+	TRASH_HISTORY_PREFIX = TRASH_PREFIX + "hist_"
+
+	HISTORY_PREFIX = "hist_"
+
+	UNDO_KEY = "undo"
+
+	JOURNAL_PREFIX = "journal_"
+)
+
+func noteKey(notename string) string {
+	return NOTE_PREFIX + notename
+}
+
+// itemPrefix returns the prefix of the keys of all items of a note.
+func itemPrefix(notename string) string {
+	return ITEM_PREFIX + notename + "_"
+}
+
+func itemKey(notename string, itemid int) string {
+	return fmt.Sprintf("%s%09d", itemPrefix(notename), itemid)
+}
+
+// parseItemKey returns the note name and item ID of an item key, and
+// whether key is one. Item IDs have no "_", so the name of a note created
+// with "_" by former versions is kept whole.
+func parseItemKey(key string) (notename string, itemid int, ok bool) {
+	if !strings.HasPrefix(key, ITEM_PREFIX) {
+		return "", 0, false
+	}
+	key = strings.TrimPrefix(key, ITEM_PREFIX)
+	i := strings.LastIndex(key, "_")
+	if i <= 0 {
+		return "", 0, false
+	}
+	itemid, err := strconv.Atoi(key[i+1:])
+	if err != nil {
+		return "", 0, false
+	}
+	return key[:i], itemid, true
+}
+
+func trashNoteKey(notename string) string {
+	return TRASH_NOTE_PREFIX + notename
+}
+
+// trashItemPrefix returns the prefix of the keys of all removed items of a
+// note.
+func trashItemPrefix(notename string) string {
+	return TRASH_ITEM_PREFIX + notename + "_"
+}
+
+func trashItemKey(notename string, itemid int) string {
+	return fmt.Sprintf("%s%09d", trashItemPrefix(notename), itemid)
+}
+
+// historyNotePrefix returns the prefix of the keys of revisions of all items
+// of a note.
+func historyNotePrefix(notename string) string {
+	return HISTORY_PREFIX + notename + "_"
+}
+
+// historyPrefix returns the prefix of the keys of all revisions of an item.
+func historyPrefix(notename string, itemid int) string {
+	return fmt.Sprintf("%s%09d_", historyNotePrefix(notename), itemid)
+}
+
+func historyKey(notename string, itemid int, rev int) string {
+	return fmt.Sprintf("%s%06d", historyPrefix(notename, itemid), rev)
+}
+
+// trashHistoryNotePrefix returns the prefix of the keys of revisions of all
+// removed items of a note.
+func trashHistoryNotePrefix(notename string) string {
+	return TRASH_HISTORY_PREFIX + notename + "_"
+}
+
+// trashHistoryPrefix returns the prefix of the keys of all revisions of a
+// removed item.
+func trashHistoryPrefix(notename string, itemid int) string {
+	return fmt.Sprintf("%s%09d_", trashHistoryNotePrefix(notename), itemid)
+}
+
+// keyOfNote reports whether key, starting with the prefix of a note, belongs
+// to the note rather than to a note whose name starts with the name and
+// "_". The rest of a key of the note has parts separated by "_", e.g. 1 for
+// item_<note>_<id>, and 2 for hist_<note>_<id>_<rev>.
+func keyOfNote(key []byte, prefix string, parts int) bool {
+	rest := strings.TrimPrefix(string(key), prefix)
+	return strings.Count(rest, "_") == parts-1
+}
+
+func journalKey(t time.Time) string {
+	return fmt.Sprintf("%s%020d", JOURNAL_PREFIX, t.UnixNano())
+}
+
+// ScanPrefix calls fn with every key starting with prefix and its value, in
+// key order, until fn returns an error. The writes of the running txn are
+// seen.
+func (notedb *NoteDB) ScanPrefix(prefix string, fn func(key, value []byte) error) error {
+	pending := notedb.pendingWrites(prefix)
+
+	iter := notedb.db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	defer iter.Release()
+
+	// merge the pending writes into the keys in database
+	emitPending := func() error {
+		key := pending[0]
+		pending = pending[1:]
+		value := notedb.tx.writes[key]
+		if value == nil { // deleted
+			return nil
+		}
+		return fn([]byte(key), value)
+	}
+
+	for iter.Next() {
+		key := string(iter.Key())
+		for len(pending) > 0 && pending[0] < key {
+			err := emitPending()
+			if err != nil {
+				return err
+			}
+		}
+		if len(pending) > 0 && pending[0] == key { // overwritten or deleted
+			err := emitPending()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		err := fn(iter.Key(), iter.Value())
+		if err != nil {
+			return err
+		}
+	}
+	err := iter.Error()
+	if err != nil {
+		return err
+	}
+
+	for len(pending) > 0 {
+		err := emitPending()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveKeys moves the keys of a note starting with prefix from to the same
+// keys starting with prefix to, see keyOfNote for parts.
+func (notedb *NoteDB) moveKeys(from, to string, parts int) error {
+	return notedb.ScanPrefix(from, func(key, value []byte) error {
+		if !keyOfNote(key, from, parts) {
+			return nil
+		}
+		err := notedb.put(to+strings.TrimPrefix(string(key), from), value)
+		if err != nil {
+			return err
+		}
+		return notedb.delete(string(key))
+	})
+}
@@ -0,0 +1,47 @@
+package main
+
+import "testing"
+
+func TestKeyOfNote(t *testing.T) {
+	tests := []struct {
+		key, prefix string
+		parts       int
+		expected    bool
+	}{
+		{itemKey("a", 1), itemPrefix("a"), 1, true},
+		{itemKey("a_b", 1), itemPrefix("a"), 1, false},
+		{itemKey("a_b", 1), itemPrefix("a_b"), 1, true},
+		{historyKey("a", 1, 2), historyNotePrefix("a"), 2, true},
+		{historyKey("a_b", 1, 2), historyNotePrefix("a"), 2, false},
+		{historyKey("a_b", 1, 2), historyNotePrefix("a_b"), 2, true},
+	}
+	for _, test := range tests {
+		if keyOfNote([]byte(test.key), test.prefix, test.parts) != test.expected {
+			t.Errorf("keyOfNote(%s, %s, %d) should be %v",
+				test.key, test.prefix, test.parts, test.expected)
+		}
+	}
+}
+
+func TestParseItemKey(t *testing.T) {
+	tests := []struct {
+		key      string
+		notename string
+		itemid   int
+		ok       bool
+	}{
+		{itemKey("a", 1), "a", 1, true},
+		{itemKey("a_b", 12), "a_b", 12, true},
+		{"item_a", "", 0, false},
+		{"item__000000001", "", 0, false},
+		{"item_a_x", "", 0, false},
+		{trashItemKey("a", 1), "", 0, false},
+	}
+	for _, test := range tests {
+		notename, itemid, ok := parseItemKey(test.key)
+		if notename != test.notename || itemid != test.itemid || ok != test.ok {
+			t.Errorf("parseItemKey(%s) should be %s, %d, %v, got %s, %d, %v", test.key,
+				test.notename, test.itemid, test.ok, notename, itemid, ok)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+var (
+	// default time to wait for another cnote process to release the database
+	DEFAULT_LOCK_WAIT = 2 * time.Second
+	// interval between two attempts of opening a locked database
+	LOCK_RETRY_INTERVAL = 100 * time.Millisecond
+	// file in database directory recording the process holding the lock
+	LOCK_OWNER_FILE = "OWNER"
+	// attempts of copying a locked database, see openCopy
+	COPY_ATTEMPTS = 3
+)
+
+// openWithRetry calls open until it succeeds, fails with an error other
+// than a lock error, or wait is exceeded.
+func openWithRetry(dbfile string, wait time.Duration, open func() error) error {
+	deadline := time.Now().Add(wait)
+	for {
+		err := open()
+		if err == nil {
+			return nil
+		}
+		if !isLockError(err) {
+			return errors.New(
+				fmt.Sprintf("fail to open leveldb file: %s. %s", dbfile, err))
+		}
+		if time.Now().After(deadline) {
+			return lockedError(dbfile, wait)
+		}
+		time.Sleep(LOCK_RETRY_INTERVAL)
+	}
+}
+
+func lockedError(dbfile string, wait time.Duration) error {
+	msg := fmt.Sprintf("database \"%s\" is locked by another cnote process", dbfile)
+
+	data, err := ioutil.ReadFile(filepath.Join(dbfile, LOCK_OWNER_FILE))
+	if err == nil {
+		fields := strings.SplitN(strings.TrimSpace(string(data)), "\t", 3)
+		if len(fields) == 3 {
+			msg += fmt.Sprintf(" (pid %s: \"%s\", since %s)",
+				fields[0], fields[1], fields[2])
+		}
+	}
+
+	return errors.New(fmt.Sprintf("%s. gave up after waiting %s, "+
+		"use \"cnote --wait DURATION\" to wait longer.", msg, wait))
+}
+
+// writeLockOwner records the current process as the holder of the lock.
+// It is only informative, so failures are ignored.
+func writeLockOwner(dbfile string) {
+	owner := fmt.Sprintf("%d\t%s\t%s\n", os.Getpid(),
+		strings.Join(os.Args, " "), time.Now().Format(TIME_FORMAT))
+	ioutil.WriteFile(filepath.Join(dbfile, LOCK_OWNER_FILE), []byte(owner), 0644)
+}
+
+func removeLockOwner(dbfile string) {
+	os.Remove(filepath.Join(dbfile, LOCK_OWNER_FILE))
+}
+
+// copyDB copies the files of a leveldb database, except its lock, into a
+// temporary directory. The files are copied one by one while a writer may
+// change them, so the copy may be inconsistent, see openCopy.
+func copyDB(dbfile string) (string, error) {
+	files, err := ioutil.ReadDir(dbfile)
+	if err != nil {
+		return "", err
+	}
+
+	copydir, err := ioutil.TempDir("", "cnote")
+	if err != nil {
+		return "", err
+	}
+
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || name == "LOCK" || name == LOCK_OWNER_FILE ||
+			strings.HasPrefix(name, "LOG") {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(dbfile, name))
+		if err != nil {
+			if os.IsNotExist(err) { // removed by compaction of the writer
+				continue
+			}
+			os.RemoveAll(copydir)
+			return "", err
+		}
+		err = ioutil.WriteFile(filepath.Join(copydir, name), data, 0644)
+		if err != nil {
+			os.RemoveAll(copydir)
+			return "", err
+		}
+	}
+
+	return copydir, nil
+}
+
+// openCopy opens a read-only copy of a locked leveldb database, and returns
+// it with the directory of the copy. The copy is verified by reading all
+// keys, and made again if the writer changed the database meanwhile.
+func openCopy(dbfile string) (*leveldb.DB, string, error) {
+	var err error
+	for i := 0; i < COPY_ATTEMPTS; i++ {
+		var copydir string
+		copydir, err = copyDB(dbfile)
+		if err != nil {
+			return nil, "", err
+		}
+
+		var db *leveldb.DB
+		db, err = leveldb.OpenFile(copydir, &opt.Options{ReadOnly: true})
+		if err == nil {
+			iter := db.NewIterator(nil, nil)
+			for iter.Next() {
+			}
+			iter.Release()
+			err = iter.Error()
+			if err == nil {
+				return db, copydir, nil
+			}
+			db.Close()
+		}
+		os.RemoveAll(copydir)
+	}
+	return nil, "", errors.New(fmt.Sprintf("inconsistent copy after %d attempts. %s",
+		COPY_ATTEMPTS, err))
+}
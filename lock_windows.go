@@ -0,0 +1,17 @@
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+const (
+	ERROR_SHARING_VIOLATION syscall.Errno = 32
+	ERROR_LOCK_VIOLATION    syscall.Errno = 33
+)
+
+// isLockError reports whether err is caused by the file lock of leveldb
+// being held by another process.
+func isLockError(err error) bool {
+	return errors.Is(err, ERROR_SHARING_VIOLATION) || errors.Is(err, ERROR_LOCK_VIOLATION)
+}
@@ -1,20 +1,37 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"os/user"
 	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/codegangsta/cli"
+	"github.com/jinzhu/now"
 )
 
+// commands only reading the database, which can run while another cnote
+// process is writing. "tag" is read-only unless managing tags.
+var READONLY_COMMANDS = map[string]bool{
+	"list": true, "tag": true, "search": true, "show": true, "reveal": true,
+	"cat": true, "pinned": true, "due": true, "dump": true, "backup": true,
+	"backups": true, "log": true, "diff": true, "history": true,
+}
+
+// version of cnote, recorded in backup archives
+var VERSION = "1.2 (2014-07-22)"
+
 var (
-	funcs  map[string]func(c *cli.Context)
-	DBFILE string
-	notedb *NoteDB
+	funcs map[string]func(c *cli.Context)
+	// subcommands of "cnote tag" managing tags, others are taken as tags
+	tagFuncs map[string]func(c *cli.Context, note *Note, args []string)
+	DBFILE   string
+	notedb   *NoteDB
 )
 
 func init() {
@@ -31,23 +48,79 @@ func init() {
 	funcs["del"] = funDel
 	funcs["use"] = funUse
 	funcs["list"] = funLs
+	funcs["rename"] = funRename
 
 	funcs["add"] = funAdd
 	funcs["rm"] = funRm
+	funcs["mv"] = funMv
+	funcs["cp"] = funCp
 
 	funcs["tag"] = funTag
+	funcs["retag"] = funRetag
+	funcs["pin"] = funPin
+	funcs["unpin"] = funUnpin
+	funcs["pinned"] = funPinned
+	funcs["due"] = funDue
+	funcs["done"] = funDone
+	funcs["undone"] = funUndone
+	funcs["check"] = funCheck
+	funcs["uncheck"] = funUncheck
 	funcs["search"] = funSearch
+	funcs["show"] = funShow
+	funcs["reveal"] = funReveal
+	funcs["cat"] = funCat
 
 	funcs["dump"] = funDump
 	funcs["wipe"] = funWipe
 	funcs["restore"] = funRestore
+	funcs["backup"] = funBackup
+	funcs["backups"] = funBackups
+	funcs["rollback"] = funRollback
 	funcs["import"] = funImport
 
+	funcs["trash"] = funTrash
+	funcs["restore-item"] = funRestoreItem
+	funcs["restore-note"] = funRestoreNote
+	funcs["undo"] = funUndo
+
+	funcs["log"] = funLog
+	funcs["diff"] = funDiff
+	funcs["revert"] = funRevert
+	funcs["history"] = funHistory
+
+	funcs["config"] = funConfig
+
+	tagFuncs = make(map[string]func(c *cli.Context, note *Note, args []string))
+	tagFuncs["rename"] = funTagRename
+	tagFuncs["merge"] = funTagMerge
+	tagFuncs["delete"] = funTagDelete
+	tagFuncs["alias"] = funTagAlias
+	tagFuncs["unalias"] = funTagUnalias
+	tagFuncs["normalize"] = funTagNormalize
+	for name := range tagFuncs {
+		RESERVED_TAGS[name] = true
+	}
 }
 
 func getFunc(funcs map[string]func(c *cli.Context), name string) func(c *cli.Context) {
 	if f, ok := funcs[name]; ok {
-		return f
+		// open the database only for the commands which need it
+		return func(c *cli.Context) {
+			readOnly := READONLY_COMMANDS[name]
+			if name == "tag" { // "cnote tag rename" etc. write
+				_, ok := tagFuncs[c.Args().First()]
+				readOnly = !ok
+			}
+
+			err := openDB(c, readOnly)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			defer notedb.Close()
+
+			f(c)
+		}
 	} else {
 		return func(c *cli.Context) {
 			fmt.Printf("command %s not implemented\n", name)
@@ -55,12 +128,35 @@ func getFunc(funcs map[string]func(c *cli.Context), name string) func(c *cli.Con
 	}
 }
 
+func openDB(c *cli.Context, readOnly bool) error {
+	wait, err := time.ParseDuration(c.GlobalString("wait"))
+	if err != nil {
+		return errors.New(fmt.Sprintf("invalid value of --wait: %s", err))
+	}
+
+	notedb, err = NewNoteDB(DBFILE, wait, readOnly)
+	if err != nil {
+		return err
+	}
+
+	// "_" was allowed in note names before CheckNoteName
+	for _, notename := range notedb.NotesList {
+		if strings.Contains(notename, "_") {
+			fmt.Fprintf(os.Stderr, "note name \"%s\" contains \"_\", which is not "+
+				"allowed now. please rename it: \"cnote rename %s NEWNAME\".\n",
+				notename, notename)
+		}
+	}
+	return nil
+}
+
 func funLs(c *cli.Context) {
 	if len(c.Args()) > 0 {
 		fmt.Println("no arguments should be given.")
 		return
 	}
 
+	notes := make([]*Note, 0, len(notedb.NotesList))
 	for _, notename := range notedb.NotesList {
 
 		// read note
@@ -69,9 +165,30 @@ func funLs(c *cli.Context) {
 			fmt.Println(err)
 			return
 		}
+		notes = append(notes, note)
+	}
+
+	err := SortNotes(notes, sortOrder(c, "name"), c.Bool("reverse"))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	start, end := Page(len(notes), c.Int("offset"), c.Int("limit"))
 
-		fmt.Printf("note: %s\t(#. of items: %d, last update: %s).",
-			notename, note.Sum, note.LastUpdate)
+	for _, note := range notes[start:end] {
+		notename := note.NoteID
+		if note.Checklist {
+			done, total, err := notedb.Progress(note)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			fmt.Printf("note: %s\t(#. of items: %d, %d/%d done, last update: %s).",
+				notename, note.Sum, done, total, note.LastUpdate)
+		} else {
+			fmt.Printf("note: %s\t(#. of items: %d, last update: %s).",
+				notename, note.Sum, note.LastUpdate)
+		}
 		if notedb.CurrentNote != nil &&
 			notename == notedb.CurrentNote.NoteID {
 
@@ -93,7 +210,7 @@ func funNew(c *cli.Context) {
 
 	notename := c.Args().First()
 
-	err := notedb.NewNote(notename)
+	err := notedb.NewNote(notename, c.Bool("checklist"))
 	if err != nil {
 		fmt.Println(err)
 		return
@@ -140,6 +257,22 @@ func funDel(c *cli.Context) {
 	}
 }
 
+func funRename(c *cli.Context) {
+	if len(c.Args()) != 2 {
+		fmt.Println("old and new note name needed.")
+		return
+	}
+	oldname, newname := c.Args()[0], c.Args()[1]
+
+	err := notedb.RenameNote(oldname, newname)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Printf("note \"%s\" renamed to \"%s\".\n", oldname, newname)
+}
+
 func funUse(c *cli.Context) {
 	if len(c.Args()) == 0 {
 		fmt.Println("note name needed.")
@@ -167,7 +300,35 @@ func funAdd(c *cli.Context) {
 		return
 	}
 
-	item, err := notedb.AddNoteItem(c.Args()[0], c.Args()[1])
+	opts := &ItemOptions{}
+	if c.String("due") != "" {
+		due, err := parseDue(c.String("due"))
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		opts.Due = due
+	}
+	fields, err := parseFields(c.StringSlice("field"))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	opts.Fields = fields
+	if c.Bool("secret") {
+		if len(fields) > 0 {
+			fmt.Println("--field can not be used with --secret, " +
+				"fields of secret items are not encrypted.")
+			return
+		}
+		opts.Passphrase, err = readPassphrase(true)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+
+	item, err := notedb.AddNoteItem(c.Args()[0], c.Args()[1], opts)
 	if err != nil {
 		fmt.Println(err)
 		return
@@ -190,7 +351,7 @@ func funRm(c *cli.Context) {
 			continue
 		}
 
-		// read item and print it, in case of misdeleteing
+		// read item and print it, it can be restored from trash
 		item, err := notedb.ReadNoteItem(notedb.CurrentNote, itemid)
 		if err != nil {
 			fmt.Println(err)
@@ -207,6 +368,51 @@ func funRm(c *cli.Context) {
 	}
 }
 
+func funMv(c *cli.Context) {
+	copyItems(c, true)
+}
+
+func funCp(c *cli.Context) {
+	copyItems(c, false)
+}
+
+func copyItems(c *cli.Context, move bool) {
+	if len(c.Args()) == 0 {
+		fmt.Println("item ID needed.")
+		return
+	}
+	destname := c.String("to")
+	if destname == "" {
+		fmt.Println("destination note needed (--to NOTE).")
+		return
+	}
+
+	itemids := make([]int, 0)
+	for _, itemid := range c.Args() {
+		itemid, err := strconv.Atoi(itemid)
+		if err != nil {
+			fmt.Println("item ID should be positive integer.")
+			return
+		}
+		itemids = append(itemids, itemid)
+	}
+
+	items, err := notedb.CopyNoteItems(notedb.CurrentNote, itemids, destname, move)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	action := "copied"
+	if move {
+		action = "moved"
+	}
+	for i, item := range items {
+		fmt.Printf("item %d %s to note \"%s\" as item %s.\n",
+			itemids[i], action, destname, item.ItemID)
+	}
+}
+
 func funTag(c *cli.Context) {
 	// list all tags
 	note, err := notedb.GetCurrentNote()
@@ -215,13 +421,47 @@ func funTag(c *cli.Context) {
 		return
 	}
 
+	if f, ok := tagFuncs[c.Args().First()]; ok {
+		f(c, note, c.Args().Tail())
+		return
+	}
+
+	if c.Bool("tree") {
+		printTagTree(TagStats(note), "", "")
+		return
+	}
+
+	if c.Bool("untagged") {
+		items, err := notedb.UntaggedItems()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		printItems(c, items)
+		return
+	}
+
 	if len(c.Args()) == 0 {
 		tagstats := make([]TagStat, 0)
-		for tag, taginfo := range note.Tags {
-			tagstats = append(tagstats, TagStat{tag, len(taginfo)})
+		for _, tagstat := range TagStats(note) {
+			if tagstat.Amount > 0 { // not only an ancestor of other tags
+				tagstats = append(tagstats, tagstat)
+			}
 		}
 		sort.Sort(SortTagsByAmount(tagstats))
-		for _, tagstat := range tagstats {
+		err := SortTags(tagstats, sortOrder(c, "count"), c.Bool("reverse"))
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		start, end := Page(len(tagstats), c.Int("offset"), c.Int("limit"))
+
+		for _, tagstat := range tagstats[start:end] {
+			if tagstat.Total > tagstat.Amount {
+				fmt.Printf("tag: %s\t(#. of items: %d, with subtags: %d).\n",
+					tagstat.Tag, tagstat.Amount, tagstat.Total)
+				continue
+			}
 			fmt.Printf("tag: %s\t(#. of items: %d).\n", tagstat.Tag, tagstat.Amount)
 		}
 		return
@@ -233,188 +473,1227 @@ func funTag(c *cli.Context) {
 		return
 	}
 
-	for _, item := range items {
-		fmt.Println(item)
-	}
+	printItems(c, items)
 }
 
-func funSearch(c *cli.Context) {
-	if len(c.Args()) == 0 {
-		fmt.Println("search keyword needed.")
-		return
+// sortOrder returns the order given by --sort, or defaultOrder.
+func sortOrder(c *cli.Context, defaultOrder string) string {
+	if c.String("sort") != "" {
+		return c.String("sort")
 	}
+	return defaultOrder
+}
 
-	items, err := notedb.ItemByRegexp(c.Args())
+// printItems sorts items by --sort (by ID by default), with pinned items
+// first, and prints the page given by --offset and --limit.
+func printItems(c *cli.Context, items []*Item) {
+	err := SortItems(items, sortOrder(c, "id"), c.Bool("reverse"))
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
+	PinnedFirst(items)
+	start, end := Page(len(items), c.Int("offset"), c.Int("limit"))
 
-	for _, item := range items {
+	for _, item := range items[start:end] {
 		fmt.Println(item)
 	}
 }
 
-func funDump(c *cli.Context) {
-	if len(c.Args()) > 0 {
-		fmt.Println("no arguments should be given.")
+// listFlags are the flags of sorting and pagination of listing commands.
+func listFlags(orders string) []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{Name: "sort", Usage: "Sort by " + orders},
+		cli.BoolFlag{Name: "reverse", Usage: "Reverse the order"},
+		cli.IntFlag{Name: "limit", Usage: "List at most N entries"},
+		cli.IntFlag{Name: "offset", Usage: "Skip the first N entries"},
+	}
+}
+
+// printTagTree prints the subtree of hierarchical tags under parent, with
+// the numbers of items in each subtree.
+func printTagTree(tagstats []TagStat, parent, indent string) {
+	children := make([]TagStat, 0)
+	for _, tagstat := range tagstats {
+		if parentTag(tagstat.Tag) == parent {
+			children = append(children, tagstat)
+		}
+	}
+	sort.Sort(SortTagsByTotal(children))
+
+	for _, tagstat := range children {
+		name := tagstat.Tag
+		if parent != "" {
+			name = strings.TrimPrefix(name, parent+TAG_PATH_SEPARATOR)
+		}
+		fmt.Printf("%s%s (%d)\n", indent, name, tagstat.Total)
+		printTagTree(tagstats, tagstat.Tag, indent+"    ")
+	}
+}
+
+func funTagRename(c *cli.Context, note *Note, args []string) {
+	if len(args) != 2 {
+		fmt.Println("old and new tag needed.")
 		return
 	}
 
-	err := notedb.Dump()
+	n, err := notedb.RenameTag(note, args[0], args[1])
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
+	fmt.Printf("tag \"%s\" renamed to \"%s\" in %d items.\n", args[0], args[1], n)
 }
 
-func funWipe(c *cli.Context) {
-	if len(c.Args()) > 0 {
-		fmt.Println("no arguments should be given.")
+func funTagMerge(c *cli.Context, note *Note, args []string) {
+	if len(args) == 0 {
+		fmt.Println("tags to merge needed.")
+		return
+	}
+	into := c.String("into")
+	if into == "" {
+		fmt.Println("tag to merge into needed (--into TAG).")
 		return
 	}
 
-	reply, err := request_reply(
-		"========================================\n"+
-			" Attention, it will clear all the data.\n"+
-			"========================================\n"+
-			" Type \"%s\" to continue:",
-		"yes")
+	n, err := notedb.MergeTags(note, args, into)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
+	fmt.Printf("tags %v merged into \"%s\" in %d items.\n", args, into, n)
+}
 
-	if reply == false {
+func funTagDelete(c *cli.Context, note *Note, args []string) {
+	if len(args) != 1 {
+		fmt.Println("one tag needed.")
 		return
 	}
 
-	err = notedb.Wipe()
+	n, err := notedb.DeleteTag(note, args[0])
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
+	fmt.Printf("tag \"%s\" deleted from %d items.\n", args[0], n)
 }
 
-func funRestore(c *cli.Context) {
-	if len(c.Args()) != 1 {
-		fmt.Println("dumpped filename needed.")
+func funTagAlias(c *cli.Context, note *Note, args []string) {
+	switch len(args) {
+	case 0: // list aliases
+		aliases := make([]string, 0, len(note.Aliases))
+		for alias, _ := range note.Aliases {
+			aliases = append(aliases, alias)
+		}
+		sort.Strings(aliases)
+		for _, alias := range aliases {
+			fmt.Printf("alias: %s\t-> %s\n", alias, note.Aliases[alias])
+		}
+	case 2:
+		err := notedb.SetTagAlias(note, args[0], args[1])
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("alias: %s\t-> %s\n", args[0], note.Aliases[notedb.NormalizeTag(args[0])])
+	default:
+		fmt.Println("alias and tag needed.")
+	}
+}
+
+func funTagUnalias(c *cli.Context, note *Note, args []string) {
+	if len(args) != 1 {
+		fmt.Println("one alias needed.")
 		return
 	}
 
-	reply, err := request_reply(
-		"========================================\n"+
-			" Attention, it will clear all the data.\n"+
-			"========================================\n"+
-			" Type \"%s\" to continue:",
-		"yes")
+	err := notedb.RemoveTagAlias(note, args[0])
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
+	fmt.Printf("alias \"%s\" removed.\n", args[0])
+}
 
-	if reply == false {
+func funTagNormalize(c *cli.Context, note *Note, args []string) {
+	if len(args) > 0 {
+		fmt.Println("no arguments should be given.")
 		return
 	}
 
-	err = notedb.Restore(c.Args().First())
+	n, err := notedb.NormalizeAllTags()
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
+	fmt.Printf("tags of %d items normalized.\n", n)
 }
 
-func funImport(c *cli.Context) {
-	if len(c.Args()) != 3 {
-		fmt.Println("three arguments needed: <notename in your cnote>" +
-			" <notename in dumpped note> <dumpped filename>.")
+func funRetag(c *cli.Context) {
+	if len(c.Args()) < 2 {
+		fmt.Println("item ID and tags to add (+tag) or remove (-tag) needed.")
 		return
 	}
-	notename, othernotename, filename := c.Args()[0], c.Args()[1], c.Args()[2]
-	n, err := notedb.Import(notename, othernotename, filename)
+
+	itemid, err := strconv.Atoi(c.Args().First())
+	if err != nil {
+		fmt.Println("item ID should be positive integer.")
+		return
+	}
+
+	add := make([]string, 0)
+	remove := make([]string, 0)
+	for _, arg := range c.Args().Tail() {
+		switch {
+		case strings.HasPrefix(arg, "+"):
+			add = append(add, arg[1:])
+		case strings.HasPrefix(arg, "-"):
+			remove = append(remove, arg[1:])
+		default:
+			fmt.Printf("\"%s\" should be +tag or -tag.\n", arg)
+			return
+		}
+	}
+
+	item, err := notedb.RetagNoteItem(notedb.CurrentNote, itemid, add, remove)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
-	fmt.Printf("%d items imported into note \"%s\".\n", n, notename)
+
+	fmt.Println(item)
 }
 
-func main() {
-	notedb = NewNoteDB(DBFILE)
-	defer notedb.Close()
+func funPin(c *cli.Context) {
+	pinItems(c, true)
+}
 
-	app := cli.NewApp()
-	app.Name = "cnote"
-	app.Usage = "A platform independent command line note app. https://github.com/shenwei356/cnote"
-	app.Version = "1.2 (2014-07-22)"
-	app.Author = "Wei Shen"
-	app.Email = "shenwei356@gmail.com"
+func funUnpin(c *cli.Context) {
+	pinItems(c, false)
+}
 
-	app.Commands = []cli.Command{
-		{
-			Name:   "new",
-			Usage:  "Create a new note",
-			Action: getFunc(funcs, "new"),
-		},
-		{
-			Name:   "del",
-			Usage:  "Delete a note",
-			Action: getFunc(funcs, "del"),
-		},
-		{
-			Name:   "use",
-			Usage:  "Select a note",
-			Action: getFunc(funcs, "use"),
-		},
-		{
-			Name:      "list",
-			ShortName: "ls",
-			Usage:     "List all notes",
-			Action:    getFunc(funcs, "list"),
-		},
-		{
-			Name:   "add",
-			Usage:  "Add a note item",
-			Action: getFunc(funcs, "add"),
-		},
-		{
-			Name:   "rm",
-			Usage:  "Remove a note item",
-			Action: getFunc(funcs, "rm"),
-		},
-		{
-			Name:      "tag",
-			ShortName: "t",
-			Usage:     "List items by tags. List all tags if no arguments given",
-			Action:    getFunc(funcs, "tag"),
-		},
-		{
-			Name:      "search",
-			ShortName: "s",
-			Usage:     "Search items with regular expression",
-			Action:    getFunc(funcs, "search"),
-		},
-		{
-			Name:   "dump",
-			Usage:  "Dump whole database, for backup or transfer",
-			Action: getFunc(funcs, "dump"),
-		},
-		{
-			Name:   "wipe",
-			Usage:  "Attention! Wipe whole database",
-			Action: getFunc(funcs, "wipe"),
-		},
-		{
-			Name:   "restore",
-			Usage:  "Wipe whole database, and restore from dumpped file",
-			Action: getFunc(funcs, "restore"),
-		},
-		{
-			Name:   "import",
-			Usage:  "Import note items from dumpped data",
-			Action: getFunc(funcs, "import"),
-		},
+func pinItems(c *cli.Context, pinned bool) {
+	if len(c.Args()) == 0 {
+		fmt.Println("item ID needed.")
+		return
 	}
 
-	app.Run(os.Args)
+	for _, itemid := range c.Args() {
+
+		itemid, err := strconv.Atoi(itemid)
+		if err != nil {
+			fmt.Println("item ID should be positive integer.")
+			continue
+		}
+
+		item, err := notedb.PinNoteItem(notedb.CurrentNote, itemid, pinned)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+
+		fmt.Println(item)
+	}
+}
+
+func funPinned(c *cli.Context) {
+	if !c.Bool("all-notes") {
+		items, err := notedb.PinnedItems(notedb.CurrentNote)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		for _, item := range items {
+			fmt.Println(item)
+		}
+		return
+	}
+
+	for _, notename := range notedb.NotesList {
+		note, err := notedb.ReadNote(notename)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		items, err := notedb.PinnedItems(note)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		for _, item := range items {
+			fmt.Printf("%s\t(note: %s)\n", item, notename)
+		}
+	}
+}
+
+func funDue(c *cli.Context) {
+	var before time.Time
+	switch {
+	case c.Bool("overdue"):
+		before = time.Now()
+	case c.Bool("today"):
+		before = now.EndOfDay()
+	case c.Bool("week"):
+		before = now.EndOfWeek()
+	}
+
+	dueitems, err := notedb.DueItems(before)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	for _, dueitem := range dueitems {
+		fmt.Println(dueitem)
+	}
+}
+
+func funDone(c *cli.Context) {
+	setItemsStatus(c, ITEM_DONE)
+}
+
+func funUndone(c *cli.Context) {
+	setItemsStatus(c, "")
+}
+
+func funCheck(c *cli.Context) {
+	if c.Bool("doing") {
+		setItemsStatus(c, ITEM_DOING)
+		return
+	}
+	setItemsStatus(c, ITEM_DONE)
+}
+
+func funUncheck(c *cli.Context) {
+	setItemsStatus(c, ITEM_TODO)
+}
+
+func setItemsStatus(c *cli.Context, status string) {
+	if len(c.Args()) == 0 {
+		fmt.Println("item ID needed.")
+		return
+	}
+
+	for _, itemid := range c.Args() {
+
+		itemid, err := strconv.Atoi(itemid)
+		if err != nil {
+			fmt.Println("item ID should be positive integer.")
+			continue
+		}
+
+		item, err := notedb.SetItemStatus(notedb.CurrentNote, itemid, status)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+
+		fmt.Println(item)
+	}
+}
+
+func funSearch(c *cli.Context) {
+	fields, err := parseFields(c.StringSlice("field"))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	queries := []string(c.Args())
+	if len(queries) == 0 {
+		if len(fields) == 0 {
+			fmt.Println("search keyword needed.")
+			return
+		}
+		queries = []string{""} // matches all items, even of empty content
+	}
+
+	items, err := notedb.ItemByRegexp(queries)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	matched := make([]*Item, 0, len(items))
+	for _, item := range items {
+		if item.HasFields(fields) {
+			matched = append(matched, item)
+		}
+	}
+
+	printItems(c, matched)
+}
+
+func funShow(c *cli.Context) {
+	if len(c.Args()) == 0 {
+		fmt.Println("item ID needed.")
+		return
+	}
+
+	for i, itemid := range c.Args() {
+
+		itemid, err := strconv.Atoi(itemid)
+		if err != nil {
+			fmt.Println("item ID should be positive integer.")
+			continue
+		}
+
+		item, err := notedb.ReadNoteItem(notedb.CurrentNote, itemid)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Println(item.Details(notedb.CurrentNote.NoteID))
+	}
+}
+
+func funReveal(c *cli.Context) {
+	if len(c.Args()) == 0 {
+		fmt.Println("item ID needed.")
+		return
+	}
+
+	passphrase, err := readPassphrase(false)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	for _, itemid := range c.Args() {
+
+		itemid, err := strconv.Atoi(itemid)
+		if err != nil {
+			fmt.Println("item ID should be positive integer.")
+			continue
+		}
+
+		item, err := notedb.RevealNoteItem(notedb.CurrentNote, itemid, passphrase)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+
+		fmt.Println(item)
+	}
+}
+
+func funCat(c *cli.Context) {
+	if len(c.Args()) > 1 {
+		fmt.Println("at most one note name needed.")
+		return
+	}
+
+	note := notedb.CurrentNote
+	if len(c.Args()) == 1 {
+		var err error
+		note, err = notedb.ReadNote(c.Args().First())
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+
+	if c.String("format") != "" {
+		items, err := notedb.ReadNoteItems(note)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		err = WriteItems(os.Stdout, items, c.String("format"))
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	err := notedb.EachItem(note, func(item *Item) error {
+		fmt.Println(item)
+		return nil
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+func funDump(c *cli.Context) {
+	if len(c.Args()) > 0 {
+		fmt.Println("no arguments should be given.")
+		return
+	}
+
+	keys, err := dumpKeys(c, c.Bool("encrypt"), true)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	var filter *DumpFilter
+	if c.String("note") != "" || c.String("tag") != "" || c.String("search") != "" {
+		filter = &DumpFilter{
+			Note:   c.String("note"),
+			Tag:    c.String("tag"),
+			Search: c.String("search"),
+		}
+	}
+
+	err = notedb.Dump(os.Stdout, keys, filter)
+	if err != nil {
+		// not into the dump, and failing scripts redirecting it
+		fmt.Fprintln(os.Stderr, err)
+		notedb.Close()
+		os.Exit(1)
+	}
+}
+
+// dumpKeys returns the keys of encrypted dumps given by flags, asking for
+// the passphrase if needed. nil is returned for plain dumps.
+func dumpKeys(c *cli.Context, passphrase, confirm bool) (*DumpKeys, error) {
+	keys := &DumpKeys{
+		Recipients:   c.StringSlice("recipient"),
+		IdentityFile: c.String("identity"),
+	}
+	if passphrase {
+		var err error
+		keys.Passphrase, err = readPassphrase(confirm)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if keys.Passphrase == "" && len(keys.Recipients) == 0 && keys.IdentityFile == "" {
+		return nil, nil
+	}
+	return keys, nil
+}
+
+func funWipe(c *cli.Context) {
+	if len(c.Args()) > 0 {
+		fmt.Println("no arguments should be given.")
+		return
+	}
+
+	reply, err := request_reply(
+		"========================================\n"+
+			" Attention, it will clear all the data.\n"+
+			"========================================\n"+
+			" Type \"%s\" to continue:",
+		"yes")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if reply == false {
+		return
+	}
+
+	err = notedb.Wipe()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+}
+
+func funRestore(c *cli.Context) {
+	if len(c.Args()) != 1 {
+		fmt.Println("dumpped filename needed.")
+		return
+	}
+
+	reply, err := request_reply(
+		"========================================\n"+
+			" Attention, it will clear all the data.\n"+
+			"========================================\n"+
+			" Type \"%s\" to continue:",
+		"yes")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if reply == false {
+		return
+	}
+
+	keys, err := dumpKeys(c, c.Bool("decrypt"), false)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	err = notedb.Restore(c.Args().First(), keys)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+}
+
+func funBackup(c *cli.Context) {
+	if len(c.Args()) != 1 {
+		fmt.Println("backup filename needed.")
+		return
+	}
+
+	manifest, err := notedb.Backup(c.Args().First())
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Println(manifest)
+}
+
+func funBackups(c *cli.Context) {
+	if len(c.Args()) > 0 {
+		fmt.Println("no arguments should be given.")
+		return
+	}
+
+	names, err := notedb.Backups()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	for _, name := range names {
+		_, manifest, err := notedb.BackupPath(name)
+		if err != nil {
+			fmt.Printf("%s\t%s\n", name, err)
+			continue
+		}
+		fmt.Printf("%s\t%s\n", name, manifest)
+	}
+}
+
+func funRollback(c *cli.Context) {
+	if len(c.Args()) != 1 {
+		fmt.Println("backup name needed, see \"cnote backups\".")
+		return
+	}
+
+	path, manifest, err := notedb.BackupPath(c.Args().First())
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(manifest)
+
+	reply, err := request_reply(
+		"========================================\n"+
+			" Attention, it will clear all the data.\n"+
+			"========================================\n"+
+			" Type \"%s\" to continue:",
+		"yes")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if reply == false {
+		return
+	}
+
+	err = notedb.Restore(path, nil)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+}
+
+func funImport(c *cli.Context) {
+	if c.String("from") != "" {
+		importFrom(c)
+		return
+	}
+
+	if len(c.Args()) != 3 {
+		fmt.Println("three arguments needed: <notename in your cnote>" +
+			" <notename in dumpped note> <dumpped filename>.")
+		return
+	}
+	notename, othernotename, filename := c.Args()[0], c.Args()[1], c.Args()[2]
+	n, err := notedb.Import(notename, othernotename, filename)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("%d items imported into note \"%s\".\n", n, notename)
+}
+
+func importFrom(c *cli.Context) {
+	if len(c.Args()) != 2 {
+		fmt.Println("two arguments needed: <notename in your cnote> <filename>.")
+		return
+	}
+	notename, filename := c.Args()[0], c.Args()[1]
+
+	opts := &ImportOptions{
+		Format:       c.String("from"),
+		TagsCol:      c.Int("tags-col"),
+		ContentCol:   c.Int("content-col"),
+		Header:       c.Bool("header"),
+		TagsField:    c.String("tags-field"),
+		ContentField: c.String("content-field"),
+		Tag:          c.String("tag"),
+	}
+	n, lineErrors, err := notedb.ImportFrom(notename, filename, opts)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	for _, e := range lineErrors {
+		fmt.Printf("%s: %s\n", filename, e)
+	}
+	fmt.Printf("%d items imported into note \"%s\", %d lines skipped.\n",
+		n, notename, len(lineErrors))
+}
+
+func funTrash(c *cli.Context) {
+	if len(c.Args()) > 0 {
+		fmt.Println("no arguments should be given.")
+		return
+	}
+
+	if c.Bool("empty") {
+		reply, err := request_reply(
+			"==================================================\n"+
+				" Attention, it will delete all the data in trash.\n"+
+				"==================================================\n"+
+				" Type \"%s\" to continue:",
+			"yes")
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		if reply == false {
+			return
+		}
+
+		n, err := notedb.EmptyTrash()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("%d notes and items deleted from trash.\n", n)
+		return
+	}
+
+	trashnotes, trashitems, err := notedb.ListTrash()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, trashnote := range trashnotes {
+		fmt.Println(trashnote)
+	}
+	for _, trashitem := range trashitems {
+		fmt.Println(trashitem)
+	}
+}
+
+func funRestoreItem(c *cli.Context) {
+	if len(c.Args()) == 0 {
+		fmt.Println("item ID needed.")
+		return
+	}
+
+	for _, itemid := range c.Args() {
+
+		itemid, err := strconv.Atoi(itemid)
+		if err != nil {
+			fmt.Println("item ID should be positive integer.")
+			continue
+		}
+
+		item, err := notedb.RestoreItem(notedb.CurrentNote, itemid)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+
+		fmt.Println(item)
+	}
+}
+
+func funRestoreNote(c *cli.Context) {
+	if len(c.Args()) != 1 {
+		fmt.Println("only one note name allowed.")
+		return
+	}
+	notename := c.Args().First()
+
+	err := notedb.RestoreNote(notename)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Printf("note \"%s\" restored.\n", notename)
+}
+
+func funUndo(c *cli.Context) {
+	if len(c.Args()) > 0 {
+		fmt.Println("no arguments should be given.")
+		return
+	}
+
+	undo, err := notedb.Undo()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Printf("undone: %s (%s).\n", undo.Op, undo.Time)
+}
+
+func funLog(c *cli.Context) {
+	if len(c.Args()) != 1 {
+		fmt.Println("one item ID needed.")
+		return
+	}
+
+	itemid, err := strconv.Atoi(c.Args().First())
+	if err != nil {
+		fmt.Println("item ID should be positive integer.")
+		return
+	}
+
+	item, err := notedb.ReadNoteItem(notedb.CurrentNote, itemid)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	revs, err := notedb.ItemHistory(notedb.CurrentNote, itemid)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	for _, rev := range revs {
+		fmt.Println(rev)
+	}
+	fmt.Printf("current\t(tags: %v)\t%s\n", item.Tags, item.ShownContent())
+}
+
+func funDiff(c *cli.Context) {
+	if len(c.Args()) != 3 {
+		fmt.Println("item ID and two revisions (e.g. r1 r2, or current) needed.")
+		return
+	}
+
+	itemid, err := strconv.Atoi(c.Args().First())
+	if err != nil {
+		fmt.Println("item ID should be positive integer.")
+		return
+	}
+
+	versions := make([]*Item, 2)
+	for i, s := range c.Args()[1:] {
+		if s == "current" {
+			versions[i], err = notedb.ReadNoteItem(notedb.CurrentNote, itemid)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			continue
+		}
+
+		rev, err := ParseRevision(s)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		revision, err := notedb.ReadRevision(notedb.CurrentNote, itemid, rev)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		versions[i] = &revision.Item
+	}
+
+	fmt.Println(DiffItems(versions[0], versions[1]))
+}
+
+func funRevert(c *cli.Context) {
+	if len(c.Args()) != 2 {
+		fmt.Println("item ID and revision (e.g. r1) needed.")
+		return
+	}
+
+	itemid, err := strconv.Atoi(c.Args().First())
+	if err != nil {
+		fmt.Println("item ID should be positive integer.")
+		return
+	}
+	rev, err := ParseRevision(c.Args()[1])
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	item, err := notedb.RevertNoteItem(notedb.CurrentNote, itemid, rev)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Println(item)
+}
+
+func funHistory(c *cli.Context) {
+	if len(c.Args()) > 0 {
+		fmt.Println("no arguments should be given.")
+		return
+	}
+
+	var since time.Time
+	if c.String("since") != "" {
+		var err error
+		since, err = parseSince(c.String("since"))
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+
+	entries, err := notedb.Journal(since)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	for _, entry := range entries {
+		fmt.Println(entry)
+	}
+}
+
+func funConfig(c *cli.Context) {
+	switch len(c.Args()) {
+	case 0: // list all settings
+		for _, name := range SettingNames() {
+			fmt.Printf("%s = %s\t(%s)\n", name, notedb.Config.Get(name), SETTINGS[name].Usage)
+		}
+	case 1:
+		name := c.Args().First()
+		if _, ok := SETTINGS[name]; !ok {
+			fmt.Printf("unknown setting \"%s\".\n", name)
+			return
+		}
+		fmt.Printf("%s = %s\n", name, notedb.Config.Get(name))
+	case 2:
+		name, value := c.Args()[0], c.Args()[1]
+		err := notedb.Config.Set(name, value)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("%s = %s\n", name, notedb.Config.Get(name))
+	default:
+		fmt.Println("at most two arguments (setting and value) allowed.")
+	}
+}
+
+func main() {
+	app := cli.NewApp()
+	app.Name = "cnote"
+	app.Usage = "A platform independent command line note app. https://github.com/shenwei356/cnote"
+	app.Version = VERSION
+	app.Author = "Wei Shen"
+	app.Email = "shenwei356@gmail.com"
+
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:  "wait",
+			Value: DEFAULT_LOCK_WAIT.String(),
+			Usage: "How long to wait for the database locked by another cnote process",
+		},
+	}
+
+	app.Commands = []cli.Command{
+		{
+			Name:   "config",
+			Usage:  "Show or change settings",
+			Action: getFunc(funcs, "config"),
+		},
+		{
+			Name:   "new",
+			Usage:  "Create a new note",
+			Action: getFunc(funcs, "new"),
+			Flags: []cli.Flag{
+				cli.BoolFlag{Name: "checklist", Usage: "Create a checklist, whose items are tasks"},
+			},
+		},
+		{
+			Name:   "del",
+			Usage:  "Delete a note (to trash)",
+			Action: getFunc(funcs, "del"),
+		},
+		{
+			Name:   "rename",
+			Usage:  "Rename a note",
+			Action: getFunc(funcs, "rename"),
+		},
+		{
+			Name:   "use",
+			Usage:  "Select a note",
+			Action: getFunc(funcs, "use"),
+		},
+		{
+			Name:      "list",
+			ShortName: "ls",
+			Usage:     "List all notes",
+			Action:    getFunc(funcs, "list"),
+			Flags:     listFlags("name (default), count or updated"),
+		},
+		{
+			Name:   "add",
+			Usage:  "Add a note item",
+			Action: getFunc(funcs, "add"),
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "due", Usage: "Due date, like \"tomorrow\", \"next friday\", \"3d\" or \"2014-07-20 10:00\""},
+				cli.StringSliceFlag{Name: "field", Value: &cli.StringSlice{}, Usage: "Field as key=value, can be given multiple times"},
+				cli.BoolFlag{Name: "secret", Usage: "Encrypt the content with a passphrase (or $CNOTE_PASSPHRASE)"},
+			},
+		},
+		{
+			Name:   "rm",
+			Usage:  "Remove a note item (to trash)",
+			Action: getFunc(funcs, "rm"),
+		},
+		{
+			Name:   "mv",
+			Usage:  "Move note items to another note",
+			Action: getFunc(funcs, "mv"),
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "to", Usage: "Destination note"},
+			},
+		},
+		{
+			Name:   "cp",
+			Usage:  "Copy note items to another note",
+			Action: getFunc(funcs, "cp"),
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "to", Usage: "Destination note"},
+			},
+		},
+		{
+			Name:      "tag",
+			ShortName: "t",
+			Usage:     "List items by tags. List all tags if no arguments given",
+			Action:    getFunc(funcs, "tag"),
+			Flags: append(listFlags("count (default) or name for tags, "+
+				"id (default), content, created, updated or tags for items"),
+				cli.StringFlag{Name: "into", Usage: "Tag to merge into, for \"tag merge\""},
+				cli.BoolFlag{Name: "tree", Usage: "Show hierarchical tags as a tree"},
+				cli.BoolFlag{Name: "untagged", Usage: "List items without any tag"},
+			),
+		},
+		{
+			Name:            "retag",
+			Usage:           "Add (+tag) or remove (-tag) tags of a note item",
+			Action:          getFunc(funcs, "retag"),
+			SkipFlagParsing: true,
+		},
+		{
+			Name:      "pin",
+			ShortName: "star",
+			Usage:     "Pin note items, pinned items are listed first",
+			Action:    getFunc(funcs, "pin"),
+		},
+		{
+			Name:      "unpin",
+			ShortName: "unstar",
+			Usage:     "Unpin note items",
+			Action:    getFunc(funcs, "unpin"),
+		},
+		{
+			Name:      "pinned",
+			ShortName: "starred",
+			Usage:     "List pinned items",
+			Action:    getFunc(funcs, "pinned"),
+			Flags: []cli.Flag{
+				cli.BoolFlag{Name: "all-notes", Usage: "List pinned items of all notes"},
+			},
+		},
+		{
+			Name:   "due",
+			Usage:  "List items with due dates of all notes, which are not done",
+			Action: getFunc(funcs, "due"),
+			Flags: []cli.Flag{
+				cli.BoolFlag{Name: "overdue", Usage: "Only items past due"},
+				cli.BoolFlag{Name: "today", Usage: "Only items due by the end of today"},
+				cli.BoolFlag{Name: "week", Usage: "Only items due by the end of this week"},
+			},
+		},
+		{
+			Name:   "done",
+			Usage:  "Mark note items as done",
+			Action: getFunc(funcs, "done"),
+		},
+		{
+			Name:   "undone",
+			Usage:  "Mark note items as not done",
+			Action: getFunc(funcs, "undone"),
+		},
+		{
+			Name:   "check",
+			Usage:  "Mark tasks of a checklist note as done (or doing)",
+			Action: getFunc(funcs, "check"),
+			Flags: []cli.Flag{
+				cli.BoolFlag{Name: "doing", Usage: "Mark as doing instead of done"},
+			},
+		},
+		{
+			Name:   "uncheck",
+			Usage:  "Mark tasks of a checklist note as todo",
+			Action: getFunc(funcs, "uncheck"),
+		},
+		{
+			Name:      "search",
+			ShortName: "s",
+			Usage:     "Search items with regular expression",
+			Action:    getFunc(funcs, "search"),
+			Flags: append(listFlags("id (default), content, created, updated or tags"),
+				cli.StringSliceFlag{Name: "field", Value: &cli.StringSlice{}, Usage: "Only items with field key=value, can be given multiple times"},
+			),
+		},
+		{
+			Name:   "show",
+			Usage:  "Show details of note items",
+			Action: getFunc(funcs, "show"),
+		},
+		{
+			Name:   "reveal",
+			Usage:  "Show the decrypted content of secret items",
+			Action: getFunc(funcs, "reveal"),
+		},
+		{
+			Name:   "cat",
+			Usage:  "Print all items of a note (the current one by default)",
+			Action: getFunc(funcs, "cat"),
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "format", Usage: "Print as csv or tsv, with fields as columns"},
+			},
+		},
+		{
+			Name:   "dump",
+			Usage:  "Dump whole database, for backup or transfer",
+			Action: getFunc(funcs, "dump"),
+			Flags: []cli.Flag{
+				cli.BoolFlag{Name: "encrypt", Usage: "Encrypt with a passphrase (or $CNOTE_PASSPHRASE)"},
+				cli.StringSliceFlag{Name: "recipient", Value: &cli.StringSlice{}, Usage: "Encrypt to an age public key (age1...), can be given multiple times"},
+				cli.StringFlag{Name: "note", Usage: "Only dump the note"},
+				cli.StringFlag{Name: "tag", Usage: "Only dump items with the tag (or its subtags)"},
+				cli.StringFlag{Name: "search", Usage: "Only dump items matching the regular expression"},
+			},
+		},
+		{
+			Name:   "wipe",
+			Usage:  "Attention! Wipe whole database",
+			Action: getFunc(funcs, "wipe"),
+		},
+		{
+			Name:   "backup",
+			Usage:  "Back up whole database to a compressed archive with checksum",
+			Action: getFunc(funcs, "backup"),
+		},
+		{
+			Name:   "backups",
+			Usage:  "List automatic backups (see \"cnote config\" for backup.*)",
+			Action: getFunc(funcs, "backups"),
+		},
+		{
+			Name:   "rollback",
+			Usage:  "Wipe whole database, and restore an automatic backup",
+			Action: getFunc(funcs, "rollback"),
+		},
+		{
+			Name:   "restore",
+			Usage:  "Wipe whole database, and restore from dumpped file or backup archive",
+			Action: getFunc(funcs, "restore"),
+			Flags: []cli.Flag{
+				cli.BoolFlag{Name: "decrypt", Usage: "Decrypt with a passphrase (or $CNOTE_PASSPHRASE)"},
+				cli.StringFlag{Name: "identity", Usage: "Decrypt with age secret keys in the file"},
+			},
+		},
+		{
+			Name:   "import",
+			Usage:  "Import note items from dumpped data, or csv, tsv, jsonl and txt files",
+			Action: getFunc(funcs, "import"),
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "from", Usage: "Format of the file: csv, tsv, jsonl or txt (one item per line)"},
+				cli.IntFlag{Name: "tags-col", Value: 1, Usage: "Column of tags in csv and tsv, 0 for none"},
+				cli.IntFlag{Name: "content-col", Value: 2, Usage: "Column of content in csv and tsv"},
+				cli.BoolFlag{Name: "header", Usage: "Skip the header line of csv and tsv"},
+				cli.StringFlag{Name: "tags-field", Value: "tags", Usage: "Field of tags in jsonl"},
+				cli.StringFlag{Name: "content-field", Value: "content", Usage: "Field of content in jsonl"},
+				cli.StringFlag{Name: "tag", Usage: "Tags of items without tags, and of all lines of txt"},
+			},
+		},
+		{
+			Name:   "trash",
+			Usage:  "List deleted notes and removed items",
+			Action: getFunc(funcs, "trash"),
+			Flags: []cli.Flag{
+				cli.BoolFlag{Name: "empty", Usage: "Delete all data in trash for good"},
+			},
+		},
+		{
+			Name:   "restore-item",
+			Usage:  "Bring removed note items back from trash",
+			Action: getFunc(funcs, "restore-item"),
+		},
+		{
+			Name:   "restore-note",
+			Usage:  "Bring a deleted note back from trash",
+			Action: getFunc(funcs, "restore-note"),
+		},
+		{
+			Name:   "undo",
+			Usage:  "Undo the last change",
+			Action: getFunc(funcs, "undo"),
+		},
+		{
+			Name:   "log",
+			Usage:  "Show revisions of a note item",
+			Action: getFunc(funcs, "log"),
+		},
+		{
+			Name:   "diff",
+			Usage:  "Compare two revisions of a note item",
+			Action: getFunc(funcs, "diff"),
+		},
+		{
+			Name:   "revert",
+			Usage:  "Change a note item back to a revision",
+			Action: getFunc(funcs, "revert"),
+		},
+		{
+			Name:   "history",
+			Usage:  "Show the journal of changes of the database",
+			Action: getFunc(funcs, "history"),
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "since",
+					Usage: "Only changes since a time (\"2014-07-20\") or duration ago (\"2h\", \"3d\")",
+				},
+			},
+		},
+	}
+
+	err := app.Run(os.Args)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+var (
+	MAX_NOTE_NAME_LENGTH = 64
+	// separator of tags in the tag argument of "cnote add"
+	TAG_SEPARATOR = ","
+	// separator of levels of hierarchical tags, e.g. "lang/go"
+	TAG_PATH_SEPARATOR = "/"
+	// subcommands of "cnote tag", which can not be listed as tags. They are
+	// registered with the subcommands, see tagFuncs in main.go
+	RESERVED_TAGS = make(map[string]bool)
+)
+
+// CheckNoteName returns an error if name can not be used as a note name.
+//
+// A note name consists of 1-64 letters, digits, "-" and ".", and does not
+// start with "-" or ".". In particular "_" is not allowed, since it
+// separates note name and item ID in item keys (item_<note>_<id>), so that
+// the keys of one note are never a prefix of the keys of another.
+func CheckNoteName(name string) error {
+	if name == "" {
+		return errors.New("note name should not be empty.")
+	}
+	if len([]rune(name)) > MAX_NOTE_NAME_LENGTH {
+		return errors.New(fmt.Sprintf("note name \"%s\" is too long (max %d characters).",
+			name, MAX_NOTE_NAME_LENGTH))
+	}
+	if strings.HasPrefix(name, "-") || strings.HasPrefix(name, ".") {
+		return errors.New(fmt.Sprintf("note name \"%s\" should not start with \"-\" or \".\".",
+			name))
+	}
+	for _, r := range name {
+		if !(unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '.') {
+			return errors.New(fmt.Sprintf("invalid character %q in note name \"%s\". "+
+				"only letters, digits, \"-\" and \".\" are allowed.", r, name))
+		}
+	}
+	return nil
+}
+
+// CheckTag returns an error if tag can not be used as a tag.
+//
+// A tag is not empty and contains no "," (the separator of tags) or control
+// characters. It does not start with "-" or "+", so that it is not taken as
+// an option, or as adding or removing a tag in "cnote retag". Levels of a
+// hierarchical tag, separated by "/", are not empty. The subcommands of
+// "cnote tag" (RESERVED_TAGS) are not tags.
+func CheckTag(tag string) error {
+	if strings.TrimSpace(tag) == "" {
+		return errors.New("tag should not be empty.")
+	}
+	if strings.Contains(tag, TAG_SEPARATOR) {
+		return errors.New(fmt.Sprintf("tag \"%s\" should not contain \"%s\".",
+			tag, TAG_SEPARATOR))
+	}
+	if strings.HasPrefix(tag, "-") || strings.HasPrefix(tag, "+") {
+		return errors.New(fmt.Sprintf("tag \"%s\" should not start with \"-\" or \"+\".",
+			tag))
+	}
+	if RESERVED_TAGS[tag] {
+		return errors.New(fmt.Sprintf("tag \"%s\" is reserved for \"cnote tag %s\".",
+			tag, tag))
+	}
+	for _, r := range tag {
+		if unicode.IsControl(r) {
+			return errors.New(fmt.Sprintf("invalid character %q in tag \"%s\".", r, tag))
+		}
+	}
+	for _, level := range strings.Split(tag, TAG_PATH_SEPARATOR) {
+		if strings.TrimSpace(level) == "" {
+			return errors.New(fmt.Sprintf("empty level in hierarchical tag \"%s\".", tag))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+package main
+
+import "testing"
+
+func TestSubcommandsOfTagAreNotTags(t *testing.T) {
+	for name := range tagFuncs {
+		if CheckTag(name) == nil {
+			t.Errorf("\"cnote tag %s\" can not list tag \"%s\"", name, name)
+		}
+	}
+	if err := CheckTag("lang/merge"); err != nil {
+		t.Error(err)
+	}
+}
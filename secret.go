@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+// environment variable of the passphrase of secret items, which is asked
+// for when not set
+var SECRET_ENV = "CNOTE_PASSPHRASE"
+
+// content of secret items shown in listings
+var SECRET_MASK = "********"
+
+// parameters of scrypt, for deriving keys from passphrases
+var (
+	SCRYPT_N        = 32768
+	SCRYPT_R        = 8
+	SCRYPT_P        = 1
+	SECRET_SALT_LEN = 16
+	SECRET_KEY_LEN  = 32
+)
+
+// readPassphrase returns the passphrase in SECRET_ENV, or asks for it
+// without echo. The passphrase is asked twice when confirm is true.
+func readPassphrase(confirm bool) (string, error) {
+	if passphrase := os.Getenv(SECRET_ENV); passphrase != "" {
+		return passphrase, nil
+	}
+
+	passphrase, err := promptPassphrase("passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if passphrase == "" {
+		return "", errors.New("empty passphrase.")
+	}
+
+	if confirm {
+		again, err := promptPassphrase("passphrase again: ")
+		if err != nil {
+			return "", err
+		}
+		if again != passphrase {
+			return "", errors.New("passphrases not match.")
+		}
+	}
+	return passphrase, nil
+}
+
+// reader of passphrases piped in, shared as it buffers
+var passphraseReader *bufio.Reader
+
+func promptPassphrase(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	defer fmt.Fprintln(os.Stderr)
+
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		passphrase, err := term.ReadPassword(fd)
+		return string(passphrase), err
+	}
+
+	// piped
+	if passphraseReader == nil {
+		passphraseReader = bufio.NewReader(os.Stdin)
+	}
+	line, err := passphraseReader.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// secretCipher returns AES-GCM with the key derived from passphrase.
+func secretCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt,
+		SCRYPT_N, SCRYPT_R, SCRYPT_P, SECRET_KEY_LEN)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptSecret encrypts text with passphrase, and returns the salt, nonce
+// and ciphertext in base64.
+func encryptSecret(text, passphrase string) (string, error) {
+	salt := make([]byte, SECRET_SALT_LEN)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+	aead, err := secretCipher(passphrase, salt)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+
+	data := append(salt, nonce...)
+	data = aead.Seal(data, nonce, []byte(text), nil)
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// decryptSecret reverts encryptSecret.
+func decryptSecret(secret, passphrase string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return "", errors.New("corrupted secret.")
+	}
+	if len(data) < SECRET_SALT_LEN {
+		return "", errors.New("corrupted secret.")
+	}
+	aead, err := secretCipher(passphrase, data[:SECRET_SALT_LEN])
+	if err != nil {
+		return "", err
+	}
+	data = data[SECRET_SALT_LEN:]
+	if len(data) < aead.NonceSize() {
+		return "", errors.New("corrupted secret.")
+	}
+
+	text, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("wrong passphrase or corrupted secret.")
+	}
+	return string(text), nil
+}
+
+// RevealNoteItem returns a copy of an item of note, with the content of
+// secret item decrypted.
+func (notedb *NoteDB) RevealNoteItem(note *Note, itemid int, passphrase string) (*Item, error) {
+	item, err := notedb.ReadNoteItem(note, itemid)
+	if err != nil {
+		return nil, err
+	}
+	if !item.Secret {
+		return nil, errors.New(fmt.Sprintf("item \"%d\" is not secret.", itemid))
+	}
+
+	item.Content, err = decryptSecret(item.Content, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	item.Secret = false
+	return item, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// reader reads the database. It is implemented by NoteDB, seeing the writes
+// of the running txn, and by Snapshot, so that both read notes and items
+// the same way.
+type reader interface {
+	get(key string) ([]byte, error)
+	ScanPrefix(prefix string, fn func(key, value []byte) error) error
+}
+
+// readStruct reads the JSON value of key into str.
+func readStruct(r reader, key string, str interface{}) error {
+	data, err := r.get(key)
+	if err != nil { // not exist
+		return err
+	}
+	return json.Unmarshal(data, str)
+}
+
+func readNote(r reader, notename string) (*Note, error) {
+	var note = &Note{}
+	err := readStruct(r, noteKey(notename), note)
+	if err != nil {
+		return nil, errors.New(
+			fmt.Sprintf("note \"%s\" not exist.", notename))
+	}
+	return note, nil
+}
+
+// eachItem calls fn for every item of a note in the order of item ID.
+func eachItem(r reader, notename string, fn func(item *Item) error) error {
+	prefix := itemPrefix(notename)
+	return r.ScanPrefix(prefix, func(key, value []byte) error {
+		if !keyOfNote(key, prefix, 1) {
+			return nil
+		}
+		var item = &Item{}
+		err := json.Unmarshal(value, item)
+		if err != nil {
+			return errors.New(fmt.Sprintf("fail to read %s. %v", key, err))
+		}
+		return fn(item)
+	})
+}
+
+// Snapshot is a read-only view of the database at a point in time, which
+// is not affected by later writes. Writes pending in a running txn are not
+// seen. A Snapshot must be released after use.
+type Snapshot struct {
+	snap *leveldb.Snapshot
+}
+
+// Snapshot returns a read-only view of the database as it is now.
+func (notedb *NoteDB) Snapshot() (*Snapshot, error) {
+	snap, err := notedb.db.GetSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{snap}, nil
+}
+
+// Release releases the snapshot.
+func (snapshot *Snapshot) Release() {
+	snapshot.snap.Release()
+}
+
+func (snapshot *Snapshot) get(key string) ([]byte, error) {
+	return snapshot.snap.Get([]byte(key), nil)
+}
+
+// ScanPrefix calls fn for every key starting with prefix, in the order of
+// keys. The empty prefix covers the whole database.
+func (snapshot *Snapshot) ScanPrefix(prefix string, fn func(key, value []byte) error) error {
+	iter := snapshot.snap.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		err := fn(iter.Key(), iter.Value())
+		if err != nil {
+			return err
+		}
+	}
+	return iter.Error()
+}
+
+// ReadNote returns a note.
+func (snapshot *Snapshot) ReadNote(notename string) (*Note, error) {
+	return readNote(snapshot, notename)
+}
+
+// ReadNoteItems returns all items of a note in the order of item ID.
+func (snapshot *Snapshot) ReadNoteItems(notename string) ([]*Item, error) {
+	items := make([]*Item, 0)
+	err := eachItem(snapshot, notename, func(item *Item) error {
+		items = append(items, item)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
@@ -1,8 +1,18 @@
 package main
 
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
 type TagStat struct {
 	Tag    string
 	Amount int
+	// number of items having the tag or any of its descendants
+	Total int
 }
 
 type SortTagsByAmount []TagStat
@@ -16,9 +26,29 @@ func (tags SortTagsByAmount) Swap(i, j int) {
 }
 
 func (tags SortTagsByAmount) Less(i, j int) bool {
+	if tags[i].Amount == tags[j].Amount {
+		return tags[i].Tag < tags[j].Tag
+	}
 	return tags[i].Amount > tags[j].Amount
 }
 
+type SortTagsByTotal []TagStat
+
+func (tags SortTagsByTotal) Len() int {
+	return len(tags)
+}
+
+func (tags SortTagsByTotal) Swap(i, j int) {
+	tags[i], tags[j] = tags[j], tags[i]
+}
+
+func (tags SortTagsByTotal) Less(i, j int) bool {
+	if tags[i].Total == tags[j].Total {
+		return tags[i].Tag < tags[j].Tag
+	}
+	return tags[i].Total > tags[j].Total
+}
+
 type SortItemsById []Item
 
 func (items SortItemsById) Len() int {
@@ -31,3 +61,138 @@ func (items SortItemsById) Swap(i, j int) {
 func (items SortItemsById) Less(i, j int) bool {
 	return items[i].ItemID < items[j].ItemID
 }
+
+// Orders of --sort. Numbers (count) are sorted in descending order, others
+// in ascending order.
+
+var ITEM_ORDERS = map[string]func(a, b *Item) bool{
+	"id": func(a, b *Item) bool {
+		i, _ := strconv.Atoi(a.ItemID)
+		j, _ := strconv.Atoi(b.ItemID)
+		return i < j
+	},
+	"content": func(a, b *Item) bool {
+		return a.Content < b.Content
+	},
+	"created": func(a, b *Item) bool {
+		return parseTimestamp(a.Created).Before(parseTimestamp(b.Created))
+	},
+	"updated": func(a, b *Item) bool {
+		return parseTimestamp(a.Updated).Before(parseTimestamp(b.Updated))
+	},
+	"tags": func(a, b *Item) bool {
+		return strings.Join(a.Tags, TAG_SEPARATOR) < strings.Join(b.Tags, TAG_SEPARATOR)
+	},
+}
+
+var TAG_ORDERS = map[string]func(a, b TagStat) bool{
+	"name": func(a, b TagStat) bool {
+		return a.Tag < b.Tag
+	},
+	"count": func(a, b TagStat) bool {
+		return a.Amount > b.Amount
+	},
+}
+
+var NOTE_ORDERS = map[string]func(a, b *Note) bool{
+	"name": func(a, b *Note) bool {
+		return a.NoteID < b.NoteID
+	},
+	"count": func(a, b *Note) bool {
+		return a.Sum > b.Sum
+	},
+	"updated": func(a, b *Note) bool {
+		return parseTimestamp(a.LastUpdate).Before(parseTimestamp(b.LastUpdate))
+	},
+}
+
+func unknownOrder(order string, orders []string) error {
+	sort.Strings(orders)
+	return errors.New(fmt.Sprintf("unknown sort order \"%s\", should be one of %v.",
+		order, orders))
+}
+
+// SortItems sorts items stably by order, one of ITEM_ORDERS.
+func SortItems(items []*Item, order string, reverse bool) error {
+	less, ok := ITEM_ORDERS[order]
+	if !ok {
+		orders := make([]string, 0, len(ITEM_ORDERS))
+		for o, _ := range ITEM_ORDERS {
+			orders = append(orders, o)
+		}
+		return unknownOrder(order, orders)
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		if reverse {
+			return less(items[j], items[i])
+		}
+		return less(items[i], items[j])
+	})
+	return nil
+}
+
+// PinnedFirst moves pinned items to the front, keeping the order of
+// pinned and other items.
+func PinnedFirst(items []*Item) {
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Pinned && !items[j].Pinned
+	})
+}
+
+// SortTags sorts tags stably by order, one of TAG_ORDERS.
+func SortTags(tags []TagStat, order string, reverse bool) error {
+	less, ok := TAG_ORDERS[order]
+	if !ok {
+		orders := make([]string, 0, len(TAG_ORDERS))
+		for o, _ := range TAG_ORDERS {
+			orders = append(orders, o)
+		}
+		return unknownOrder(order, orders)
+	}
+
+	sort.SliceStable(tags, func(i, j int) bool {
+		if reverse {
+			return less(tags[j], tags[i])
+		}
+		return less(tags[i], tags[j])
+	})
+	return nil
+}
+
+// SortNotes sorts notes stably by order, one of NOTE_ORDERS.
+func SortNotes(notes []*Note, order string, reverse bool) error {
+	less, ok := NOTE_ORDERS[order]
+	if !ok {
+		orders := make([]string, 0, len(NOTE_ORDERS))
+		for o, _ := range NOTE_ORDERS {
+			orders = append(orders, o)
+		}
+		return unknownOrder(order, orders)
+	}
+
+	sort.SliceStable(notes, func(i, j int) bool {
+		if reverse {
+			return less(notes[j], notes[i])
+		}
+		return less(notes[i], notes[j])
+	})
+	return nil
+}
+
+// Page returns the range [start, end) of the page of n elements, skipping
+// offset ones and taking at most limit ones (no limit if limit <= 0).
+func Page(n, offset, limit int) (int, int) {
+	start := offset
+	if start > n {
+		start = n
+	}
+	if start < 0 {
+		start = 0
+	}
+	end := n
+	if limit > 0 && start+limit < n {
+		end = start + limit
+	}
+	return start, end
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// NormalizeTag normalizes tag as configured by the settings "tag.trim",
+// "tag.nfc" and "tag.lowercase".
+func (notedb *NoteDB) NormalizeTag(tag string) string {
+	if notedb.Config.GetBool("tag.trim") {
+		tag = strings.TrimSpace(tag)
+	}
+	if notedb.Config.GetBool("tag.nfc") {
+		tag = norm.NFC.String(tag)
+	}
+	if notedb.Config.GetBool("tag.lowercase") {
+		tag = strings.ToLower(tag)
+	}
+	return tag
+}
+
+// resolveTag normalizes tag, and replaces it by its tag if it is an alias
+// in note.
+func (notedb *NoteDB) resolveTag(note *Note, tag string) string {
+	tag = notedb.NormalizeTag(tag)
+	if note != nil {
+		if t, ok := note.Aliases[tag]; ok {
+			return t
+		}
+	}
+	return tag
+}
+
+// resolveTags applies resolveTag to tags, and removes empty tags and
+// duplicates.
+func (notedb *NoteDB) resolveTags(note *Note, tags []string) []string {
+	result := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = notedb.resolveTag(note, tag)
+		if strings.TrimSpace(tag) == "" || stringInSlice(tag, result) {
+			continue
+		}
+		result = append(result, tag)
+	}
+	return result
+}
+
+// parentTag returns the parent of a hierarchical tag, or "" for a top level
+// tag.
+func parentTag(tag string) string {
+	i := strings.LastIndex(tag, TAG_PATH_SEPARATOR)
+	if i < 0 {
+		return ""
+	}
+	return tag[:i]
+}
+
+// isSubtag reports whether tag is ancestor or tag itself.
+func isSubtag(tag, ancestor string) bool {
+	return tag == ancestor || strings.HasPrefix(tag, ancestor+TAG_PATH_SEPARATOR)
+}
+
+// subtreeTags returns tag and all its descendants existing in note.
+func subtreeTags(note *Note, tag string) []string {
+	tags := make([]string, 0)
+	for t, _ := range note.Tags {
+		if isSubtag(t, tag) {
+			tags = append(tags, t)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// TagStats returns the statistics of all tags of note, including the
+// ancestors of hierarchical tags that are not used by themselves.
+func TagStats(note *Note) []TagStat {
+	// all tags and their ancestors
+	all := make(map[string]bool)
+	for tag, _ := range note.Tags {
+		for t := tag; t != ""; t = parentTag(t) {
+			all[t] = true
+		}
+	}
+
+	tagstats := make([]TagStat, 0, len(all))
+	for tag, _ := range all {
+		items := make(map[string]bool)
+		for _, t := range subtreeTags(note, tag) {
+			for itemid, _ := range note.Tags[t] {
+				items[itemid] = true
+			}
+		}
+		tagstats = append(tagstats, TagStat{tag, len(note.Tags[tag]), len(items)})
+	}
+	return tagstats
+}
+
+// itemsOfTags returns the sorted IDs of items of note having any of tags.
+func itemsOfTags(note *Note, tags []string) ([]int, error) {
+	ids := make(map[int]bool)
+	for _, tag := range tags {
+		for itemid, _ := range note.Tags[tag] {
+			itemid, err := strconv.Atoi(itemid)
+			if err != nil {
+				return nil, err
+			}
+			ids[itemid] = true
+		}
+	}
+
+	itemids := make([]int, 0, len(ids))
+	for itemid, _ := range ids {
+		itemids = append(itemids, itemid)
+	}
+	sort.Ints(itemids)
+	return itemids, nil
+}
+
+// retagItems replaces the tags of all items of note having any of tags by
+// the result of fn, and returns the number of changed items.
+func (notedb *NoteDB) retagItems(note *Note, tags []string,
+	fn func(tags []string) []string) (int, error) {
+
+	itemids, err := itemsOfTags(note, tags)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, itemid := range itemids {
+		item, err := notedb.ReadNoteItem(note, itemid)
+		if err != nil {
+			return 0, err
+		}
+
+		item.Tags = fn(item.Tags)
+		err = notedb.UpdateNoteItem(note, item)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(itemids), nil
+}
+
+// replaceTags returns tags with all of olds replaced by new, or removed if
+// new is empty, without duplicates.
+func replaceTags(tags []string, olds []string, new string) []string {
+	result := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if stringInSlice(tag, olds) {
+			if new == "" {
+				continue
+			}
+			tag = new
+		}
+		if !stringInSlice(tag, result) {
+			result = append(result, tag)
+		}
+	}
+	return result
+}
+
+func (notedb *NoteDB) checkTagsExist(note *Note, tags []string) error {
+	if note == nil {
+		return notedb.noNoteError()
+	}
+	for _, tag := range tags {
+		if _, ok := note.Tags[tag]; !ok {
+			return errors.New(
+				fmt.Sprintf("tag \"%s\" not exist in note \"%s\".", tag, note.NoteID))
+		}
+	}
+	return nil
+}
+
+// RenameTag renames a tag in all items of note, and returns the number of
+// changed items.
+func (notedb *NoteDB) RenameTag(note *Note, oldtag, newtag string) (n int, err error) {
+	notedb.begin("RenameTag", noteName(note), oldtag, newtag)
+	defer func() { err = notedb.commit(err) }()
+
+	err = notedb.checkTagsExist(note, []string{oldtag})
+	if err != nil {
+		return 0, err
+	}
+	newtag = notedb.resolveTag(note, newtag)
+	err = CheckTag(newtag)
+	if err != nil {
+		return 0, err
+	}
+	if _, ok := note.Tags[newtag]; ok {
+		return 0, errors.New(
+			fmt.Sprintf("tag \"%s\" already exist in note \"%s\". use \"cnote tag merge\".",
+				newtag, note.NoteID))
+	}
+
+	return notedb.retagItems(note, []string{oldtag}, func(tags []string) []string {
+		return replaceTags(tags, []string{oldtag}, newtag)
+	})
+}
+
+// MergeTags replaces tags by tag into in all items of note, and returns the
+// number of changed items.
+func (notedb *NoteDB) MergeTags(note *Note, tags []string, into string) (n int, err error) {
+	notedb.begin("MergeTags", append([]string{noteName(note), into}, tags...)...)
+	defer func() { err = notedb.commit(err) }()
+
+	err = notedb.checkTagsExist(note, tags)
+	if err != nil {
+		return 0, err
+	}
+	into = notedb.resolveTag(note, into)
+	err = CheckTag(into)
+	if err != nil {
+		return 0, err
+	}
+
+	return notedb.retagItems(note, tags, func(itemtags []string) []string {
+		return replaceTags(itemtags, tags, into)
+	})
+}
+
+// DeleteTag removes a tag from all items of note, and returns the number of
+// changed items. The items are kept.
+func (notedb *NoteDB) DeleteTag(note *Note, tag string) (n int, err error) {
+	notedb.begin("DeleteTag", noteName(note), tag)
+	defer func() { err = notedb.commit(err) }()
+
+	err = notedb.checkTagsExist(note, []string{tag})
+	if err != nil {
+		return 0, err
+	}
+
+	return notedb.retagItems(note, []string{tag}, func(tags []string) []string {
+		return replaceTags(tags, []string{tag}, "")
+	})
+}
+
+// RetagNoteItem adds and removes tags of an item of note.
+func (notedb *NoteDB) RetagNoteItem(note *Note, itemid int,
+	add, remove []string) (item *Item, err error) {
+
+	notedb.begin("RetagNoteItem", noteName(note), strconv.Itoa(itemid),
+		fmt.Sprint(add), fmt.Sprint(remove))
+	defer func() { err = notedb.commit(err) }()
+
+	item, err = notedb.ReadNoteItem(note, itemid)
+	if err != nil {
+		return nil, err
+	}
+
+	add = notedb.resolveTags(note, add)
+	remove = notedb.resolveTags(note, remove)
+	for _, tag := range add {
+		err = CheckTag(tag)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, tag := range remove {
+		if !stringInSlice(tag, item.Tags) {
+			return nil, errors.New(
+				fmt.Sprintf("item \"%d\" has no tag \"%s\".", itemid, tag))
+		}
+	}
+
+	item.Tags = replaceTags(item.Tags, remove, "")
+	for _, tag := range add {
+		if !stringInSlice(tag, item.Tags) {
+			item.Tags = append(item.Tags, tag)
+		}
+	}
+
+	err = notedb.UpdateNoteItem(note, item)
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// SetTagAlias makes alias an alias of tag in note. Items of note tagged
+// alias are retagged with tag.
+func (notedb *NoteDB) SetTagAlias(note *Note, alias, tag string) (err error) {
+	notedb.begin("SetTagAlias", noteName(note), alias, tag)
+	defer func() { err = notedb.commit(err) }()
+
+	if note == nil {
+		return notedb.noNoteError()
+	}
+
+	alias = notedb.NormalizeTag(alias)
+	tag = notedb.resolveTag(note, tag)
+	for _, t := range []string{alias, tag} {
+		err = CheckTag(t)
+		if err != nil {
+			return err
+		}
+	}
+	if alias == tag {
+		return errors.New(fmt.Sprintf("tag \"%s\" can not be an alias of itself.", tag))
+	}
+	for a, t := range note.Aliases {
+		if t == alias {
+			return errors.New(fmt.Sprintf("tag \"%s\" has alias \"%s\", "+
+				"it can not be an alias.", alias, a))
+		}
+	}
+
+	if _, ok := note.Tags[alias]; ok {
+		_, err = notedb.retagItems(note, []string{alias}, func(tags []string) []string {
+			return replaceTags(tags, []string{alias}, tag)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if note.Aliases == nil {
+		note.Aliases = make(map[string]string)
+	}
+	note.Aliases[alias] = tag
+	return notedb.SaveNote(note)
+}
+
+// RemoveTagAlias removes an alias of note.
+func (notedb *NoteDB) RemoveTagAlias(note *Note, alias string) (err error) {
+	notedb.begin("RemoveTagAlias", noteName(note), alias)
+	defer func() { err = notedb.commit(err) }()
+
+	if note == nil {
+		return notedb.noNoteError()
+	}
+
+	alias = notedb.NormalizeTag(alias)
+	if _, ok := note.Aliases[alias]; !ok {
+		return errors.New(
+			fmt.Sprintf("alias \"%s\" not exist in note \"%s\".", alias, note.NoteID))
+	}
+
+	delete(note.Aliases, alias)
+	return notedb.SaveNote(note)
+}
+
+// NormalizeAllTags normalizes the tags of all items of all notes and
+// replaces aliases, for tags added before changing the settings of
+// normalization. It returns the number of changed items.
+func (notedb *NoteDB) NormalizeAllTags() (n int, err error) {
+	notedb.begin("NormalizeAllTags")
+	defer func() { err = notedb.commit(err) }()
+
+	for _, notename := range notedb.NotesList {
+		note := notedb.CurrentNote
+		if note == nil || note.NoteID != notename {
+			note, err = notedb.ReadNote(notename)
+			if err != nil {
+				return n, err
+			}
+		}
+
+		items, err := notedb.ReadNoteItems(note)
+		if err != nil {
+			return n, err
+		}
+
+		for _, item := range items {
+			tags := notedb.resolveTags(note, item.Tags)
+			if strings.Join(tags, TAG_SEPARATOR) == strings.Join(item.Tags, TAG_SEPARATOR) {
+				continue
+			}
+
+			item.Tags = tags
+			err = notedb.UpdateNoteItem(note, item)
+			if err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+	return n, nil
+}
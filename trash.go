@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// TrashNote is a deleted note.
+type TrashNote struct {
+	Note      Note   `json:"note"`
+	DeletedAt string `json:"deleted_at"`
+}
+
+// TrashItem is a removed item.
+type TrashItem struct {
+	Note      string `json:"note"`
+	Item      Item   `json:"item"`
+	DeletedAt string `json:"deleted_at"`
+	// removed by deleting the note
+	WithNote bool `json:"with_note,omitempty"`
+}
+
+func (trashnote *TrashNote) String() string {
+	return fmt.Sprintf("note: %s\t(#. of items: %d, deleted at: %s).",
+		trashnote.Note.NoteID, trashnote.Note.Sum, trashnote.DeletedAt)
+}
+
+func (trashitem *TrashItem) String() string {
+	return fmt.Sprintf("%s\t(note: %s, deleted at: %s)",
+		trashitem.Item.String(), trashitem.Note, trashitem.DeletedAt)
+}
+
+// trashNoteItem moves an item of note and its revisions to the trash,
+// without saving note.
+func (notedb *NoteDB) trashNoteItem(note *Note, itemid int, withNote bool) error {
+	item, err := notedb.ReadNoteItem(note, itemid)
+	if err != nil {
+		return err
+	}
+
+	trashitem := &TrashItem{
+		Note:      note.NoteID,
+		Item:      *item,
+		DeletedAt: time.Now().Format(TIME_FORMAT),
+		WithNote:  withNote,
+	}
+	err = notedb.SaveStruct(trashItemKey(note.NoteID, itemid), trashitem)
+	if err != nil {
+		return err
+	}
+
+	err = notedb.DeleteStruct(itemKey(note.NoteID, itemid))
+	if err != nil {
+		return err
+	}
+
+	// the revisions of a replaced item in trash go with it
+	err = notedb.ScanPrefix(trashHistoryPrefix(note.NoteID, itemid), func(key, value []byte) error {
+		return notedb.delete(string(key))
+	})
+	if err != nil {
+		return err
+	}
+	err = notedb.moveKeys(historyPrefix(note.NoteID, itemid),
+		trashHistoryPrefix(note.NoteID, itemid), 1)
+	if err != nil {
+		return err
+	}
+
+	note.unindexItem(item)
+	return nil
+}
+
+// ListTrash returns all deleted notes and removed items.
+func (notedb *NoteDB) ListTrash() ([]*TrashNote, []*TrashItem, error) {
+	trashnotes := make([]*TrashNote, 0)
+	err := notedb.ScanPrefix(TRASH_NOTE_PREFIX, func(key, value []byte) error {
+		trashnote := &TrashNote{}
+		err := json.Unmarshal(value, trashnote)
+		if err != nil {
+			return err
+		}
+		trashnotes = append(trashnotes, trashnote)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	trashitems := make([]*TrashItem, 0)
+	err = notedb.ScanPrefix(TRASH_ITEM_PREFIX, func(key, value []byte) error {
+		trashitem := &TrashItem{}
+		err := json.Unmarshal(value, trashitem)
+		if err != nil {
+			return err
+		}
+		trashitems = append(trashitems, trashitem)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return trashnotes, trashitems, nil
+}
+
+// RestoreItem brings a removed item back to note, with its original ID and
+// tags.
+func (notedb *NoteDB) RestoreItem(note *Note, itemid int) (item *Item, err error) {
+	notedb.begin("RestoreItem", noteName(note), strconv.Itoa(itemid))
+	defer func() { err = notedb.commit(err) }()
+
+	if note == nil {
+		return nil, notedb.noNoteError()
+	}
+
+	trashitem := &TrashItem{}
+	key := trashItemKey(note.NoteID, itemid)
+	err = notedb.ReadStruct(key, trashitem)
+	if err != nil {
+		return nil, errors.New(
+			fmt.Sprintf("item \"%d\" not in trash of note \"%s\".",
+				itemid, note.NoteID))
+	}
+	if trashitem.WithNote {
+		return nil, errors.New(
+			fmt.Sprintf("item \"%d\" was deleted with the former note \"%s\". "+
+				"use \"cnote restore-note\".", itemid, note.NoteID))
+	}
+
+	_, err = notedb.ReadNoteItem(note, itemid)
+	if err == nil {
+		return nil, errors.New(
+			fmt.Sprintf("item \"%d\" already exist in note \"%s\".",
+				itemid, note.NoteID))
+	}
+
+	item = &trashitem.Item
+	err = notedb.SaveStruct(itemKey(note.NoteID, itemid), item)
+	if err != nil {
+		return nil, err
+	}
+	err = notedb.DeleteStruct(key)
+	if err != nil {
+		return nil, err
+	}
+	err = notedb.moveKeys(trashHistoryPrefix(note.NoteID, itemid),
+		historyPrefix(note.NoteID, itemid), 1)
+	if err != nil {
+		return nil, err
+	}
+
+	note.indexItem(item)
+	if itemid > note.LastId {
+		note.LastId = itemid
+	}
+	err = notedb.SaveNote(note)
+	if err != nil {
+		return nil, err
+	}
+
+	return item, nil
+}
+
+// RestoreNote brings a deleted note back, with the items it had when it
+// was deleted.
+func (notedb *NoteDB) RestoreNote(notename string) (err error) {
+	notedb.begin("RestoreNote", notename)
+	defer func() { err = notedb.commit(err) }()
+
+	trashnote := &TrashNote{}
+	err = notedb.ReadStruct(trashNoteKey(notename), trashnote)
+	if err != nil {
+		return errors.New(
+			fmt.Sprintf("note \"%s\" not in trash.", notename))
+	}
+
+	_, err = notedb.ReadNote(notename)
+	if err == nil {
+		return errors.New(
+			fmt.Sprintf("note \"%s\" already exist. rename it first.", notename))
+	}
+
+	note := &trashnote.Note
+	prefix := trashItemPrefix(notename)
+	err = notedb.ScanPrefix(prefix, func(key, value []byte) error {
+		if !keyOfNote(key, prefix, 1) {
+			return nil
+		}
+		trashitem := &TrashItem{}
+		err := json.Unmarshal(value, trashitem)
+		if err != nil || !trashitem.WithNote {
+			return err
+		}
+
+		itemid, err := strconv.Atoi(trashitem.Item.ItemID)
+		if err != nil {
+			return err
+		}
+		err = notedb.SaveStruct(itemKey(notename, itemid), &trashitem.Item)
+		if err != nil {
+			return err
+		}
+		err = notedb.moveKeys(trashHistoryPrefix(notename, itemid),
+			historyPrefix(notename, itemid), 1)
+		if err != nil {
+			return err
+		}
+		return notedb.delete(string(key))
+	})
+	if err != nil {
+		return err
+	}
+
+	err = notedb.SaveNote(note)
+	if err != nil {
+		return err
+	}
+	err = notedb.DeleteStruct(trashNoteKey(notename))
+	if err != nil {
+		return err
+	}
+
+	notedb.NotesList = append(notedb.NotesList, notename)
+	return nil
+}
+
+// EmptyTrash deletes all notes and items in the trash for good, together
+// with the history of the items, and returns the number of them.
+func (notedb *NoteDB) EmptyTrash() (n int, err error) {
+	notedb.begin("EmptyTrash")
+	defer func() { err = notedb.commit(err) }()
+
+	err = notedb.ScanPrefix(TRASH_ITEM_PREFIX, func(key, value []byte) error {
+		n++
+		return notedb.delete(string(key))
+	})
+	if err != nil {
+		return n, err
+	}
+
+	err = notedb.ScanPrefix(TRASH_HISTORY_PREFIX, func(key, value []byte) error {
+		return notedb.delete(string(key))
+	})
+	if err != nil {
+		return n, err
+	}
+
+	err = notedb.ScanPrefix(TRASH_NOTE_PREFIX, func(key, value []byte) error {
+		n++
+		return notedb.delete(string(key))
+	})
+	return n, err
+}
@@ -0,0 +1,60 @@
+package main
+
+import "testing"
+
+// A new note of the name of a deleted note does not get the revisions of
+// its items, which come back with the deleted note.
+func TestHistoryOfDeletedNote(t *testing.T) {
+	notedb, close := newTestDB(t, "old plan")
+	defer close()
+
+	item, err := notedb.ReadNoteItem(notedb.CurrentNote, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item.Content = "old secret plan"
+	err = notedb.UpdateNoteItem(notedb.CurrentNote, item)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = notedb.DeleteNote("fruit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = notedb.NewNote("fruit", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = notedb.AddNoteItem("fruit", "brand new", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revs, err := notedb.ItemHistory(notedb.CurrentNote, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revs) != 0 {
+		t.Fatalf("expected no revisions of the new item, got %v", revs)
+	}
+
+	err = notedb.RenameNote("fruit", "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = notedb.RestoreNote("fruit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	note, err := notedb.ReadNote("fruit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	revs, err = notedb.ItemHistory(note, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revs) != 1 || revs[0].Item.Content != "old plan" {
+		t.Fatalf("expected the revision \"old plan\" of the restored item, got %v", revs)
+	}
+}
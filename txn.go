@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// A txn collects the writes of one mutating operation of NoteDB. They are
+// applied in one batch when the operation succeeds, together with an undo
+// record holding the previous values of all written keys.
+//
+// Operations calling other operations join the running txn, so that e.g.
+// Import is applied and undone as a whole.
+type txn struct {
+	op     string
+	args   []string
+	depth  int
+	failed bool
+	// new values of written keys, nil for deleted keys
+	writes map[string][]byte
+	// do not keep revisions of changed items, see addRevisions
+	noHistory bool
+}
+
+// Undo is the record of the last mutating operation, see NoteDB.Undo.
+type Undo struct {
+	Op   string `json:"op"`
+	Time string `json:"time"`
+	// previous values of the written keys, nil for keys not existed
+	Keys map[string][]byte `json:"keys"`
+}
+
+// begin starts a txn for operation op with arguments args, or joins the
+// running one. Every begin must be paired with a commit, typically
+//
+//	notedb.begin("Op", arg)
+//	defer func() { err = notedb.commit(err) }()
+func (notedb *NoteDB) begin(op string, args ...string) {
+	if notedb.tx == nil {
+		notedb.tx = &txn{op: op, args: args, writes: make(map[string][]byte)}
+	}
+	notedb.tx.depth++
+}
+
+// commit ends the txn joined by the paired begin, and returns err. The
+// outermost commit writes all changes, unless any operation in the txn
+// failed.
+func (notedb *NoteDB) commit(err error) error {
+	tx := notedb.tx
+	tx.depth--
+	if err != nil {
+		tx.failed = true
+	}
+	if tx.depth > 0 {
+		return err
+	}
+
+	notedb.tx = nil
+	if tx.failed {
+		if err == nil {
+			err = errors.New(fmt.Sprintf("%s aborted, nothing changed.", tx.op))
+		}
+		return err
+	}
+	if len(tx.writes) == 0 {
+		return nil
+	}
+
+	err = notedb.addRevisions(tx)
+	if err != nil {
+		return err
+	}
+
+	batch := new(leveldb.Batch)
+	undo := &Undo{
+		Op:   tx.op,
+		Time: time.Now().Format(TIME_FORMAT),
+		Keys: make(map[string][]byte, len(tx.writes)),
+	}
+	for key, value := range tx.writes {
+		if key == UNDO_KEY {
+			continue
+		}
+		if value == nil {
+			batch.Delete([]byte(key))
+		} else {
+			batch.Put([]byte(key), value)
+		}
+		// the journal is append-only, undo never removes its entries
+		if strings.HasPrefix(key, JOURNAL_PREFIX) {
+			continue
+		}
+
+		old, err := notedb.db.Get([]byte(key), nil)
+		if err != nil && err != leveldb.ErrNotFound {
+			return err
+		}
+		undo.Keys[key] = old
+	}
+
+	err = batchStruct(batch, UNDO_KEY, undo)
+	if err != nil {
+		return err
+	}
+
+	err = notedb.journal(batch, tx.op, tx.args...)
+	if err != nil {
+		return err
+	}
+
+	err = notedb.db.Write(batch, nil)
+	if err != nil {
+		return err
+	}
+	return notedb.countWrite()
+}
+
+// put writes value to key, in the running txn if any.
+func (notedb *NoteDB) put(key string, value []byte) error {
+	if notedb.tx != nil {
+		// value may be the buffer of an iterator
+		notedb.tx.writes[key] = append([]byte{}, value...)
+		return nil
+	}
+	return notedb.db.Put([]byte(key), value, nil)
+}
+
+// delete deletes key, in the running txn if any.
+func (notedb *NoteDB) delete(key string) error {
+	if notedb.tx != nil {
+		notedb.tx.writes[key] = nil
+		return nil
+	}
+	return notedb.db.Delete([]byte(key), nil)
+}
+
+// get reads key, seeing the writes of the running txn.
+func (notedb *NoteDB) get(key string) ([]byte, error) {
+	if notedb.tx != nil {
+		if value, ok := notedb.tx.writes[key]; ok {
+			if value == nil {
+				return nil, leveldb.ErrNotFound
+			}
+			return value, nil
+		}
+	}
+	return notedb.db.Get([]byte(key), nil)
+}
+
+// pendingWrites returns the keys starting with prefix written in the
+// running txn, sorted.
+func (notedb *NoteDB) pendingWrites(prefix string) []string {
+	keys := make([]string, 0)
+	if notedb.tx == nil {
+		return keys
+	}
+	for key, _ := range notedb.tx.writes {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Undo reverts the last mutating operation. Only one level of undo is
+// kept, so it can not be undone itself.
+func (notedb *NoteDB) Undo() (*Undo, error) {
+	undo := &Undo{}
+	err := notedb.ReadStruct(UNDO_KEY, undo)
+	if err != nil {
+		return nil, errors.New("nothing to undo.")
+	}
+
+	batch := new(leveldb.Batch)
+	for key, value := range undo.Keys {
+		if value == nil {
+			batch.Delete([]byte(key))
+		} else {
+			batch.Put([]byte(key), value)
+		}
+	}
+	batch.Delete([]byte(UNDO_KEY))
+
+	err = notedb.journal(batch, "Undo", undo.Op, undo.Time)
+	if err != nil {
+		return nil, err
+	}
+
+	err = notedb.db.Write(batch, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// reload notes
+	list, err := notedb.GetNotesList()
+	if err != nil {
+		return nil, err
+	}
+	notedb.NotesList = list
+	notedb.CurrentNote = nil
+	err = notedb.UseNote(notedb.Config.CurrentNoteName)
+	if err != nil {
+		notedb.Config.CurrentNoteName = ""
+	}
+
+	return undo, nil
+}
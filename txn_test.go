@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// newTestDB opens a new database in a temporary directory, with a note
+// "fruit" of the given items. close closes and removes it.
+func newTestDB(t *testing.T, contents ...string) (notedb *NoteDB, close func()) {
+	dir, err := ioutil.TempDir("", "cnote")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notedb, err = NewNoteDB(filepath.Join(dir, "cnote"), 0, false)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	close = func() {
+		notedb.Close()
+		os.RemoveAll(dir)
+	}
+
+	err = notedb.NewNote("fruit", false)
+	if err != nil {
+		close()
+		t.Fatal(err)
+	}
+	for _, content := range contents {
+		_, err = notedb.AddNoteItem("fruit", content, nil)
+		if err != nil {
+			close()
+			t.Fatal(err)
+		}
+	}
+	return notedb, close
+}
+
+func scanKeys(t *testing.T, notedb *NoteDB, prefix string) []string {
+	keys := make([]string, 0)
+	err := notedb.ScanPrefix(prefix, func(key, value []byte) error {
+		keys = append(keys, string(key))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestScanPendingWrites(t *testing.T) {
+	notedb, close := newTestDB(t, "apple", "pear", "banana")
+	defer close()
+
+	notedb.begin("Test")
+	err := notedb.put(itemKey("fruit", 4), []byte("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = notedb.delete(itemKey("fruit", 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := scanKeys(t, notedb, itemPrefix("fruit"))
+	expected := []string{itemKey("fruit", 1), itemKey("fruit", 3), itemKey("fruit", 4)}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected %v in txn, got %v", expected, keys)
+	}
+	if _, err = notedb.get(itemKey("fruit", 2)); err == nil {
+		t.Errorf("deleted key %s read in txn", itemKey("fruit", 2))
+	}
+
+	// abort
+	notedb.commit(errors.New("test"))
+	keys = scanKeys(t, notedb, itemPrefix("fruit"))
+	expected = []string{itemKey("fruit", 1), itemKey("fruit", 2), itemKey("fruit", 3)}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected %v after aborting, got %v", expected, keys)
+	}
+}
+
+func TestNestedFailureAbortsTxn(t *testing.T) {
+	notedb, close := newTestDB(t)
+	defer close()
+
+	outer := func() (err error) {
+		notedb.begin("Outer")
+		defer func() { err = notedb.commit(err) }()
+
+		err = notedb.NewNote("people", false)
+		if err != nil {
+			return err
+		}
+		// invalid tag, the error is ignored here
+		notedb.AddNoteItem("-x", "alice", nil)
+		return nil
+	}
+
+	err := outer()
+	if err == nil {
+		t.Fatal("expected the txn to be aborted")
+	}
+	if _, err = notedb.ReadNote("people"); err == nil {
+		t.Error("note created in an aborted txn")
+	}
+}
+
+func TestUndo(t *testing.T) {
+	notedb, close := newTestDB(t, "apple")
+	defer close()
+
+	item, err := notedb.ReadNoteItem(notedb.CurrentNote, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item.Content = "pear"
+	err = notedb.UpdateNoteItem(notedb.CurrentNote, item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = notedb.AddNoteItem("fruit", "banana", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only the last operation is undone
+	undo, err := notedb.Undo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if undo.Op != "AddNoteItem" {
+		t.Errorf("expected AddNoteItem undone, got %s", undo.Op)
+	}
+	if _, err = notedb.ReadNoteItem(notedb.CurrentNote, 2); err == nil {
+		t.Error("item added by the undone operation still exists")
+	}
+	item, err = notedb.ReadNoteItem(notedb.CurrentNote, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Content != "pear" {
+		t.Errorf("expected \"pear\", got \"%s\"", item.Content)
+	}
+	if notedb.CurrentNote.Sum != 1 {
+		t.Errorf("expected 1 item in note, got %d", notedb.CurrentNote.Sum)
+	}
+
+	if _, err = notedb.Undo(); err == nil {
+		t.Error("expected nothing to undo")
+	}
+}
@@ -2,14 +2,19 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"os"
 	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/now"
 )
 
-func trim_prefix(p, s string) string {
-	return regexp.MustCompile("^"+p).ReplaceAllString(s, "")
-}
+// layout of timestamps stored in the database
+var TIME_FORMAT = "2006-01-02 15:04:05 -0700 MST"
 
 func request_reply(message, reply string) (bool, error) {
 	fmt.Printf(message, reply)
@@ -29,3 +34,56 @@ func request_reply(message, reply string) (bool, error) {
 
 	return true, nil
 }
+
+func stringInSlice(s string, list []string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSince parses a point of time in the past, given as a duration before
+// now like "2h" or "3d", or as a time like "2014-07-20 10:00".
+func parseSince(s string) (time.Time, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err == nil {
+			return time.Now().AddDate(0, 0, -days), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+
+	t, err := now.Parse(s)
+	if err != nil {
+		return t, errors.New(fmt.Sprintf("invalid time \"%s\".", s))
+	}
+	return t, nil
+}
+
+// parseTimestamp parses a timestamp stored in the database, the zero time
+// is returned for empty or invalid ones.
+func parseTimestamp(s string) time.Time {
+	t, err := time.Parse(TIME_FORMAT, s)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// parseFields parses fields given as key=value.
+func parseFields(list []string) (map[string]string, error) {
+	fields := make(map[string]string, len(list))
+	for _, field := range list {
+		i := strings.Index(field, "=")
+		if i <= 0 {
+			return nil, errors.New(
+				fmt.Sprintf("invalid field \"%s\", should be key=value.", field))
+		}
+		fields[strings.TrimSpace(field[:i])] = field[i+1:]
+	}
+	return fields, nil
+}